@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How an autopilot submits simulations each cycle
+type AutopilotOptions struct {
+	// Time between cycles, e.g. "10m"
+	Interval string `json:"interval"`
+	// Maximum number of simulations submitted per cycle, or 0 for no
+	// limit
+	NJobs int `json:"n"`
+	// Maximum number of jobs queued at once, or 0 for no limit
+	MaxInFlight int `json:"max_in_flight"`
+	// One of `submitPolicies`
+	Policy string `json:"policy"`
+}
+
+// State of an autopilot, saved to .glurmo/autopilot.json after every
+// cycle so that a new autopilot can pick up where the last one stopped
+type AutopilotState struct {
+	Options   AutopilotOptions `json:"options"`
+	Started   time.Time        `json:"started"`
+	LastCycle time.Time        `json:"last_cycle"`
+	Cycles    int              `json:"cycles"`
+	Submitted int              `json:"submitted"`
+	Completed int              `json:"completed"`
+	// Jobs that ended without completing, e.g. failed or timed out
+	Failed int  `json:"failed"`
+	NSims  int  `json:"n_sims"`
+	Done   bool `json:"done"`
+}
+
+// How long `glurmo autopilot -detach` waits for the new autopilot to
+// take its pidfile lock
+const autopilotStartTimeout = 30 * time.Second
+
+// Returns the path of the autopilot state file of `simDir`
+func AutopilotStatePath(simDir string) string {
+	return filepath.Join(simDir, ".glurmo", "autopilot.json")
+}
+
+// Returns the path of the autopilot pidfile of `simDir`
+func AutopilotPidPath(simDir string) string {
+	return filepath.Join(simDir, ".glurmo", "autopilot.pid")
+}
+
+// Returns the path of the log of a detached autopilot of `simDir`
+func AutopilotLogPath(simDir string) string {
+	return filepath.Join(simDir, ".glurmo", "autopilot.log")
+}
+
+// Reads the autopilot state of `simDir`. Returns false if there is
+// none.
+func LoadAutopilotState(simDir string) (AutopilotState, bool, error) {
+	state := AutopilotState{}
+	contents, err := os.ReadFile(AutopilotStatePath(simDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, errorString{fmt.Sprintf("could not read autopilot state: %s", err)}
+	}
+	err = json.Unmarshal(contents, &state)
+	if err != nil {
+		return state, false, errorString{fmt.Sprintf("could not read autopilot state %s: %s", AutopilotStatePath(simDir), err)}
+	}
+	return state, true, nil
+}
+
+// Writes `state` to the autopilot state file of `simDir`, replacing the
+// previous state in one step so that it is never left half written
+func SaveAutopilotState(simDir string, state AutopilotState) error {
+	stateJSON, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return errorString{fmt.Sprintf("could not save autopilot state: %s", err)}
+	}
+	statePath := AutopilotStatePath(simDir)
+	err = os.WriteFile(statePath+".tmp", stateJSON, 0644)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not save autopilot state: %s", err)}
+	}
+	err = os.Rename(statePath+".tmp", statePath)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not save autopilot state: %s", err)}
+	}
+	return nil
+}
+
+// Returns the pid of the autopilot running for `simDir`. An autopilot
+// holds a lock on its pidfile for as long as it runs, so a pidfile left
+// behind by one that did not get to release it, e.g. because the
+// machine was rebooted, is ignored.
+func RunningAutopilot(simDir string) (int, bool) {
+	file, err := os.Open(AutopilotPidPath(simDir))
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	if syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB) == nil {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		return 0, false
+	}
+
+	// An autopilot that has just taken the lock may not have written its
+	// pid yet
+	for attempt := 0; ; attempt++ {
+		contents, err := os.ReadFile(AutopilotPidPath(simDir))
+		pid, parseErr := strconv.Atoi(strings.TrimSpace(string(contents)))
+		if err == nil && parseErr == nil || attempt == 20 {
+			return pid, true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Takes the lock on the autopilot pidfile of `simDir` and writes the pid
+// of the current process to it, failing if another autopilot holds the
+// lock. The lock is held until the returned file is released with
+// `ReleaseAutopilotPidfile`, or the process exits.
+func AcquireAutopilotPidfile(simDir string) (*os.File, error) {
+	file, err := os.OpenFile(AutopilotPidPath(simDir), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("could not open autopilot pidfile: %s", err)}
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		file.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errorString{fmt.Sprintf("could not lock autopilot pidfile: %s", err)}
+		}
+		pid, _ := RunningAutopilot(simDir)
+		return nil, errorString{fmt.Sprintf("an autopilot is already running for %s (pid %d) - stop it with `glurmo autopilot -stop %s`",
+			simDir, pid, simDir)}
+	}
+
+	err = file.Truncate(0)
+	if err == nil {
+		_, err = fmt.Fprintln(file, os.Getpid())
+	}
+	if err != nil {
+		file.Close()
+		return nil, errorString{fmt.Sprintf("could not write autopilot pidfile: %s", err)}
+	}
+	return file, nil
+}
+
+// Empties the autopilot pidfile `file` and releases its lock. The file
+// itself is kept: removing it could let a process that opened it just
+// before lock a file no other autopilot would see.
+func ReleaseAutopilotPidfile(file *os.File) {
+	file.Truncate(0)
+	file.Close()
+}
+
+// Stops the autopilot running for `simDir`. It finishes saving its
+// state and releases its pidfile on its own.
+func StopAutopilot(simDir string) (int, error) {
+	pid, running := RunningAutopilot(simDir)
+	if !running {
+		return 0, errorString{fmt.Sprintf("no autopilot is running for %s", simDir)}
+	}
+	if pid <= 0 {
+		return 0, errorString{fmt.Sprintf("the autopilot of %s has not written its pid to %s", simDir, AutopilotPidPath(simDir))}
+	}
+	err := syscall.Kill(pid, syscall.SIGTERM)
+	if err != nil {
+		return pid, errorString{fmt.Sprintf("could not stop autopilot (pid %d): %s", pid, err)}
+	}
+	return pid, nil
+}
+
+// Starts an autopilot for `simDir` in the background, detached from the
+// terminal, with its output appended to the autopilot log. Waits until
+// the new process holds the pidfile lock, and returns its pid; if it
+// exits first, e.g. because another autopilot holds the lock, returns
+// an error.
+func DetachAutopilot(simDir string, options AutopilotOptions) (int, error) {
+	if pid, running := RunningAutopilot(simDir); running {
+		return 0, errorString{fmt.Sprintf("an autopilot is already running for %s (pid %d)", simDir, pid)}
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, errorString{fmt.Sprintf("could not start autopilot: %s", err)}
+	}
+	absSimDir, err := filepath.Abs(simDir)
+	if err != nil {
+		return 0, errorString{fmt.Sprintf("could not start autopilot: %s", err)}
+	}
+	logFile, err := os.OpenFile(AutopilotLogPath(simDir), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, errorString{fmt.Sprintf("could not open autopilot log: %s", err)}
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(executable, "autopilot",
+		"-interval", options.Interval,
+		"-n", strconv.Itoa(options.NJobs),
+		"-max-in-flight", strconv.Itoa(options.MaxInFlight),
+		"-policy", options.Policy,
+		absSimDir)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return 0, errorString{fmt.Sprintf("could not start autopilot: %s", err)}
+	}
+	pid := cmd.Process.Pid
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(autopilotStartTimeout)
+	for {
+		if runningPid, _ := RunningAutopilot(simDir); runningPid == pid {
+			return pid, nil
+		}
+		select {
+		case err := <-exited:
+			return 0, errorString{fmt.Sprintf("autopilot exited before it started (%v) - see %s", err, AutopilotLogPath(simDir))}
+		case <-deadline:
+			return 0, errorString{fmt.Sprintf("autopilot (pid %d) did not start within %s - see %s",
+				pid, autopilotStartTimeout, AutopilotLogPath(simDir))}
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// Submits simulations of `simDir` every `state.Options.Interval` until
+// every simulation has a result, or until the process is interrupted or
+// terminated. What each cycle did is logged to `w`, and `state` is saved
+// after every cycle. A cycle that fails is logged and retried at the
+// next interval.
+func RunAutopilot(w io.Writer, sched Scheduler, simDir string, state *AutopilotState) error {
+	interval, err := time.ParseDuration(state.Options.Interval)
+	if err != nil || interval <= 0 {
+		return usageError{fmt.Sprintf("the interval between cycles (-interval) should be a positive duration, e.g. 10m - got: %s", state.Options.Interval)}
+	}
+
+	pidfile, err := AcquireAutopilotPidfile(simDir)
+	if err != nil {
+		return err
+	}
+	defer ReleaseAutopilotPidfile(pidfile)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	if state.Cycles > 0 {
+		LogAutopilot(w, "resuming autopilot of %s started %s, after %d cycles and %d submitted",
+			simDir, state.Started.Format(time.DateTime), state.Cycles, state.Submitted)
+	} else {
+		LogAutopilot(w, "starting autopilot of %s, every %s", simDir, interval)
+	}
+
+	for {
+		err = AutopilotCycle(w, sched, simDir, state)
+		if err != nil {
+			LogAutopilot(w, "cycle %d failed: %s", state.Cycles, err)
+		}
+		err = SaveAutopilotState(simDir, *state)
+		if err != nil {
+			LogAutopilot(w, "%s", err)
+		}
+		if state.Done {
+			LogAutopilot(w, "all %d simulations are completed - stopping", state.NSims)
+			return nil
+		}
+
+		select {
+		case <-time.After(interval):
+		case sig := <-signals:
+			LogAutopilot(w, "received %s - stopping (run `glurmo autopilot %s` to resume)", sig, simDir)
+			return nil
+		}
+	}
+}
+
+// Runs a single autopilot cycle: checks which simulations are completed
+// or queued and submits more, as `state.Options` allows
+func AutopilotCycle(w io.Writer, sched Scheduler, simDir string, state *AutopilotState) error {
+	previousCycle := state.LastCycle
+	state.Cycles++
+	state.LastCycle = time.Now()
+
+	// Simulations whose jobs failed have no result, so they are
+	// submitted again like any other; the failures are only reported
+	if !previousCycle.IsZero() {
+		failed, nFailed, err := CountFailedJobs(sched, simDir, previousCycle)
+		if err != nil {
+			LogAutopilot(w, "could not check for failed jobs: %s", err)
+		} else if nFailed > 0 {
+			state.Failed += nFailed
+			LogAutopilot(w, "%d jobs ended without completing since the last cycle (%s) - their simulations will be submitted again",
+				nFailed, FormatStateCounts(failed))
+		}
+	}
+
+	statuses, err := CheckSimStatus(sched, simDir)
+	if err != nil {
+		return err
+	}
+	total := SimStatus{}
+	for _, status := range statuses {
+		total.Add(status)
+	}
+	state.Completed = total.Completed
+	state.NSims = total.NSims
+	if total.Completed == total.NSims {
+		state.Done = true
+		return nil
+	}
+
+	nSubmitted, err := RunJobs(io.Discard, sched, simDir, state.Options.NJobs, RunOptions{
+		Policy:      state.Options.Policy,
+		MaxInFlight: state.Options.MaxInFlight,
+	})
+	state.Submitted += nSubmitted
+	LogAutopilot(w, "cycle %d: %d/%d completed, %d running, %d pending - submitted %d (%d in total)",
+		state.Cycles, total.Completed, total.NSims, total.Running, total.Pending, nSubmitted, state.Submitted)
+	return err
+}
+
+// Returns how many jobs of the simulation directories at or below
+// `simDir` ended without completing since `since`, by the state they
+// ended in and in total
+func CountFailedJobs(sched Scheduler, simDir string, since time.Time) (map[string]int, int, error) {
+	history, err := sched.History(since)
+	if err != nil {
+		return nil, 0, err
+	}
+	leafDirs, err := GetLeafDirs(simDir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	failed := make(map[string]int)
+	nFailed := 0
+	for _, leafDir := range leafDirs {
+		settingsMap, err := GetSettings(leafDir)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, job := range FilterSimJobs(history, settingsMap.ID()) {
+			if job.State != "COMPLETED" {
+				failed[job.State]++
+				nFailed++
+			}
+		}
+	}
+	return failed, nFailed, nil
+}
+
+// Formats counts of jobs by state as `FAILED 2, TIMEOUT 1`
+func FormatStateCounts(counts map[string]int) string {
+	parts := make([]string, 0, len(counts))
+	for _, state := range SortedKeys(counts) {
+		parts = append(parts, fmt.Sprintf("%s %d", state, counts[state]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Writes a timestamped line to the autopilot log `w`
+func LogAutopilot(w io.Writer, format string, a ...any) {
+	fmt.Fprintf(w, "%s %s\n", time.Now().Format(time.DateTime), fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCountFailedJobs(t *testing.T) {
+	simDir := setupTestGrid(t)
+	id1, id2 := testGridIDs(t, simDir)
+	sched := &memScheduler{history: []Job{
+		{"1", id1 + "___0", "COMPLETED"},
+		{"2", id1 + "___1", "FAILED"},
+		{"3", id2 + "___0", "TIMEOUT"},
+		{"4", id2 + "___1", "FAILED"},
+		{"5", "other___0", "FAILED"},
+	}}
+
+	failed, nFailed, err := CountFailedJobs(sched, simDir, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"FAILED": 2, "TIMEOUT": 1}; nFailed != 3 || !reflect.DeepEqual(failed, want) {
+		t.Errorf("counted %d failed: %v, want %v", nFailed, failed, want)
+	}
+	if got := FormatStateCounts(failed); got != "FAILED 2, TIMEOUT 1" {
+		t.Errorf("FormatStateCounts = %q", got)
+	}
+}
+
+func TestAutopilotPidfile(t *testing.T) {
+	simDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(simDir, ".glurmo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, running := RunningAutopilot(simDir); running {
+		t.Fatal("an autopilot is running without a pidfile")
+	}
+
+	pidfile, err := AcquireAutopilotPidfile(simDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, running := RunningAutopilot(simDir); !running || pid != os.Getpid() {
+		t.Errorf("RunningAutopilot = %d, %t, want %d, true", pid, running, os.Getpid())
+	}
+	// The lock is per open file, so a second autopilot is refused even in
+	// the same process
+	if second, err := AcquireAutopilotPidfile(simDir); err == nil {
+		ReleaseAutopilotPidfile(second)
+		t.Errorf("acquired the pidfile of a running autopilot")
+	}
+
+	ReleaseAutopilotPidfile(pidfile)
+	if _, running := RunningAutopilot(simDir); running {
+		t.Errorf("an autopilot is running after its pidfile was released")
+	}
+	// A pidfile left behind with a live pid in it is not a running
+	// autopilot
+	if err := os.WriteFile(AutopilotPidPath(simDir), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pidfile, err = AcquireAutopilotPidfile(simDir)
+	if err != nil {
+		t.Fatalf("could not take over a stale pidfile: %s", err)
+	}
+	ReleaseAutopilotPidfile(pidfile)
+}
@@ -3,10 +3,9 @@ package main
 import (
 	"fmt"
 	"path/filepath"
-	"strings"
 )
 
-func CancelJobs(simDir string, nJobsToCancel int, stateMap map[string]bool) (int, error) {
+func CancelJobs(sched Scheduler, simDir string, nJobsToCancel int, stateMap map[string]bool) (int, error) {
 	nCanceled := 0
 	resultsExists, err := DirExists(filepath.Join(simDir, "results"))
 	if err != nil {
@@ -24,7 +23,7 @@ func CancelJobs(simDir string, nJobsToCancel int, stateMap map[string]bool) (int
 			if subdir == ".glurmo" {
 				continue
 			}
-			canceledJobs, err := CancelJobs(filepath.Join(simDir, subdir), nJobsToCancel, stateMap)
+			canceledJobs, err := CancelJobs(sched, filepath.Join(simDir, subdir), nJobsToCancel, stateMap)
 			if err != nil {
 				return nCanceled, err
 			}
@@ -38,9 +37,9 @@ func CancelJobs(simDir string, nJobsToCancel int, stateMap map[string]bool) (int
 			return -1, errorString{fmt.Sprintf("failed to cancel jobs in directory `%s`: %s", simDir, err)}
 		}
 
-		simID := settingsMap.General["id"]
+		simID := settingsMap.ID()
 
-		submittedJobs, err := GetCurrentSubmitted(simID)
+		submittedJobs, err := GetCurrentSubmitted(sched, simID)
 		if err != nil {
 			return 0, errorString{fmt.Sprintf("failed to cancel jobs: %s", err)}
 		}
@@ -50,8 +49,8 @@ func CancelJobs(simDir string, nJobsToCancel int, stateMap map[string]bool) (int
 
 		for nCanceled < nJobsToCancel && curJobNum < nJobs {
 			curJob := submittedJobs[curJobNum]
-			if strings.HasPrefix(curJob.JobName, simID) && stateMap[curJob.State] {
-				_, err := CommandString("scancel", curJob.ID)
+			if JobBelongsToSim(curJob.JobName, simID) && stateMap[curJob.State] {
+				err := sched.Cancel(curJob)
 				if err != nil {
 					return 0, errorString{fmt.Sprintf("failed to cancel jobs: %s", err)}
 				}
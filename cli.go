@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Exit codes returned by glurmo. `exitUsage` is reserved for
+// malformed command lines, so scripts can tell "you called glurmo
+// wrong" apart from "glurmo failed".
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// A glurmo subcommand. `Usage` is the synopsis shown after
+// `glurmo`, `Summary` is the one line description shown in the
+// command list, and `Description` is the longer text shown by
+// `glurmo help <command>`. `Run` receives the arguments following
+// the command name.
+type Command struct {
+	Name        string
+	Usage       string
+	Summary     string
+	Description string
+	Run         func(args []string) error
+}
+
+// Returns all glurmo subcommands, in the order they are listed
+// in the help output
+func Commands() []Command {
+	return []Command{
+		{
+			Name:    "setup",
+			Usage:   "setup [-incremental [-clean]] [-yes | -force | -no-clobber] <sim dir>",
+			Summary: "set up a simulation directory from its .glurmo settings",
+			Description: "Reads the settings and templates in <sim dir>/.glurmo and creates the\n" +
+				"scripts, slurm files and output directories for every simulation.\n" +
+				"By default, previous setups are removed first, along with leaves that are\n" +
+				"no longer part of the grid. With -incremental, only new leaves are created\n" +
+				"and scripts and slurm files whose inputs changed are rewritten; results,\n" +
+				"slurm_out and slurm_errors are never touched unless -clean is also given.\n\n" +
+				"If setup would overwrite or remove existing contents, it lists them and\n" +
+				"asks before going ahead. When stdin is not a terminal it fails instead,\n" +
+				"unless -yes, -force or -no-clobber says what to do. With -incremental, it\n" +
+				"only asks if it would remove something, e.g. results that -clean removes.",
+			Run: RunSetupCommand,
+		},
+		{
+			Name:    "extend",
+			Usage:   "extend (-by <n> | -to <n>) <sim dir>",
+			Summary: "add simulations to a directory that has been set up",
+			Description: "Increases n_sims of every simulation directory at or below <sim dir>,\n" +
+				"by <n> or to <n>, rendering only the scripts and slurm files of the new\n" +
+				"simulations. Existing scripts, slurm files and results are not touched.\n" +
+				"The stored settings are updated, so `glurmo run` submits the new\n" +
+				"simulations like any others. If <sim dir> is the root of a grid, n_sims\n" +
+				"in its settings is increased too.",
+			Run: RunExtendCommand,
+		},
+		{
+			Name:    "run",
+			Usage:   "run (-n <jobs> | -max-in-flight <jobs>) [-policy <policy>] [-per-leaf] <sim dir>",
+			Summary: "submit simulations that have not been run yet",
+			Description: "Submits up to <jobs> simulations that are neither completed nor currently\n" +
+				"submitted. If <sim dir> is a meta directory, <jobs> is shared by every\n" +
+				"simulation directory below it, split according to <policy>:\n" +
+				"  depth-first   fill each directory in turn (the default)\n" +
+				"  round-robin   take one simulation from each directory in turn\n" +
+				"  proportional  split in proportion to how many each has left to submit\n" +
+				"With -per-leaf, up to <jobs> are submitted in every simulation directory.\n\n" +
+				"With -max-in-flight, jobs that are already running or pending count against\n" +
+				"<jobs>, so only enough are submitted to top the queue up to <jobs>; running\n" +
+				"it repeatedly, e.g. from cron, keeps the queue at a steady depth. Combined\n" +
+				"with -n, at most -n are submitted at a time. Combined with -per-leaf, every\n" +
+				"simulation directory is topped up to <jobs>.\n\n" +
+				"If the settings set \"scheduler\" to \"pbs\" in the \"general\" section,\n" +
+				"simulations are submitted to PBS Pro with qsub instead of slurm (Torque is\n" +
+				"not supported, since its qstat cannot print JSON). If they set it\n" +
+				"to \"condor\", they are submitted to HTCondor with a submit description,\n" +
+				"written to the condor subdirectory, that is rendered from\n" +
+				".glurmo/condor_template, or generated from the slurm file if there is no\n" +
+				"such template. If they set it to \"local\", simulations run on\n" +
+				"this machine, \"local_workers\" at a time, and run waits for them to finish.",
+			Run: RunRunCommand,
+		},
+		{
+			Name:    "autopilot",
+			Usage:   "autopilot (-n <jobs> | -max-in-flight <jobs>) [-interval <duration>] [-policy <policy>] [-detach] <sim dir>\n       glurmo autopilot -stop <sim dir>",
+			Summary: "keep submitting simulations until every one has a result",
+			Description: "Checks on <sim dir> every <duration> (10m by default) and submits more\n" +
+				"simulations, like `glurmo run`, until every simulation has a result. Each\n" +
+				"cycle is logged, and its state is saved to .glurmo/autopilot.json, so an\n" +
+				"autopilot that was stopped, e.g. by a reboot, resumes where it left off when\n" +
+				"started again; options that are not given are those it was started with.\n" +
+				"Jobs that ended without completing since the last cycle, e.g. because they\n" +
+				"failed or ran out of time, are logged; their simulations have no result, so\n" +
+				"they are submitted again.\n\n" +
+				"With -detach, the autopilot runs in the background, logging to\n" +
+				".glurmo/autopilot.log, with its pid in .glurmo/autopilot.pid. Only one\n" +
+				"autopilot can run for a directory at a time; -stop stops it.",
+			Run: RunAutopilotCommand,
+		},
+		{
+			Name:    "cancel",
+			Usage:   "cancel -n <jobs> [-state <states>] <sim dir>",
+			Summary: "cancel submitted simulations",
+			Description: "Cancels up to <jobs> submitted simulations that are in one of the given\n" +
+				"states. If <sim dir> is a meta directory, cancels up to <jobs> in every\n" +
+				"simulation directory below it.",
+			Run: RunCancelCommand,
+		},
+		{
+			Name:    "status",
+			Usage:   "status <sim dir>",
+			Summary: "report how many simulations are completed, running, pending or not submitted",
+			Description: "Reports, for every simulation directory at or below <sim dir>, the number of\n" +
+				"simulations that are completed, running, pending, or not yet submitted,\n" +
+				"followed by a total for the whole tree.",
+			Run: RunStatusCommand,
+		},
+		{
+			Name:    "lint",
+			Usage:   "lint <sim dir>",
+			Summary: "check the settings and templates of a simulation directory",
+			Description: "Checks the settings and templates in <sim dir>/.glurmo and reports every\n" +
+				"problem found: missing or malformed settings, malformed lists, template\n" +
+				"syntax errors, settings used by a template but not defined, and settings\n" +
+				"not used by any template. The same checks run before `glurmo setup`.",
+			Run: RunLintCommand,
+		},
+		{
+			Name:    "render",
+			Usage:   "render [-leaf <leaf>] [-index <index>] <sim dir>",
+			Summary: "print the script and slurm file of one simulation without writing them",
+			Description: "Renders the script and slurm templates of a single simulation and prints\n" +
+				"them, along with the settings glurmo injects into the templates. Nothing\n" +
+				"is written to disk, so this works before `glurmo setup`. If <sim dir> has\n" +
+				"list or dict variables, -leaf chooses the leaf to render.",
+			Run: RunRenderCommand,
+		},
+		{
+			Name:    "fakeslurm",
+			Usage:   "fakeslurm install [-pending-delay <duration>] [-fail-rate <p>] [-timeout-rate <p>] [-slots <n>] <dir>\n       glurmo fakeslurm -dir <dir> (sbatch | squeue | scancel | sacct) [slurm options]",
+			Summary: "emulate slurm on this machine, to rehearse a study without a cluster",
+			Description: "Installs a fake slurm in <dir>: sbatch, squeue, scancel and sacct scripts\n" +
+				"that keep a queue of jobs in <dir>. Put <dir> first on your PATH and glurmo,\n" +
+				"or anything else, uses them instead of slurm.\n\n" +
+				"Submitted jobs stay PENDING for <duration> (1s by default), then become\n" +
+				"RUNNING once one of <n> slots is free and run their batch script for real,\n" +
+				"with output and errors written where #SBATCH --output and --error say.\n" +
+				"They end COMPLETED or FAILED depending on how the script exits, or TIMEOUT if\n" +
+				"they run past their --time. A fraction <p> of jobs can be made to fail without\n" +
+				"running (-fail-rate) or to time out as soon as they start (-timeout-rate).\n" +
+				"The jobs are run by a single background process, which sbatch starts when\n" +
+				"there is none and which exits once no job is queued. Finished jobs are kept\n" +
+				"for sacct for a day.\n\n" +
+				"The commands understand the options glurmo uses and print what slurm would,\n" +
+				"e.g. `squeue --noheader --format=\"%i %j %T\" --me`.",
+			Run: RunFakeSlurmCommand,
+		},
+		{
+			Name:        "help",
+			Usage:       "help [command]",
+			Summary:     "show help for glurmo or one of its commands",
+			Description: "Shows the list of commands, or the usage of a single command.",
+			Run:         RunHelpCommand,
+		},
+	}
+}
+
+// Looks up the command called `name`; returns false if there
+// is no such command
+func FindCommand(name string) (Command, bool) {
+	for _, cmd := range Commands() {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
+// Runs glurmo with command line arguments `args` (not including the
+// executable name), and returns the exit code
+func RunCLI(args []string) int {
+	if len(args) == 0 {
+		PrintUsage(os.Stderr)
+		return exitUsage
+	}
+
+	name := args[0]
+	if name == "-h" || name == "-help" || name == "--help" {
+		name = "help"
+	}
+
+	cmd, found := FindCommand(name)
+	if !found {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown command `%s`\n\n", name)
+		PrintUsage(os.Stderr)
+		return exitUsage
+	}
+
+	err := cmd.Run(args[1:])
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+	var uErr usageError
+	if errors.As(err, &uErr) {
+		fmt.Fprintf(os.Stderr, "Run `glurmo help %s` for usage.\n", cmd.Name)
+		return exitUsage
+	}
+	return exitError
+}
+
+// Prints the list of glurmo commands to `w`
+func PrintUsage(w io.Writer) {
+	fmt.Fprintln(w, "glurmo is a simulation manager for the slurm workload manager.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  glurmo <command> [options] <sim dir>")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range Commands() {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run `glurmo help <command>` for more information about a command.")
+}
+
+// Returns a flag set for the command called `name`. Parse errors and
+// help requests are not printed by the flag set itself; they are handled
+// by `ParseCommandArgs` and `RunCLI`.
+func NewCommandFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	return fs
+}
+
+// Prints the usage message of the command whose flags are `fs`
+func PrintCommandHelp(w io.Writer, fs *flag.FlagSet) {
+	cmd, _ := FindCommand(fs.Name())
+	fmt.Fprintf(w, "Usage: glurmo %s\n\n%s\n", cmd.Usage, cmd.Description)
+
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(w, "\nOptions:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+		fs.SetOutput(io.Discard)
+	}
+}
+
+// Parses `args` with flag set `fs`, allowing flags to come before or
+// after positional arguments. Returns the positional arguments.
+func ParseCommandArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0, 1)
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				PrintCommandHelp(os.Stdout, fs)
+				return nil, err
+			}
+			return nil, usageError{err.Error()}
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	return positional, nil
+}
+
+// Given the positional arguments of a command, returns the absolute
+// path to the simulation directory. Exactly one positional argument,
+// naming an existing directory, must be given.
+func GetSimDirArg(positional []string) (string, error) {
+	if len(positional) == 0 {
+		return "", usageError{"no simulation directory given"}
+	}
+	if len(positional) > 1 {
+		return "", usageError{fmt.Sprintf("expected a single simulation directory, got %d arguments: %s",
+			len(positional), strings.Join(positional, " "))}
+	}
+
+	simDir, err := filepath.Abs(positional[0])
+	if err != nil {
+		return "", errorString{fmt.Sprintf("could not get absolute path to %s: %s", positional[0], err)}
+	}
+
+	isDir, err := DirExists(simDir)
+	if err != nil {
+		return "", errorString{fmt.Sprintf("could not access %s: %s", simDir, err)}
+	}
+	if !isDir {
+		return "", errorString{fmt.Sprintf("simulation directory %s does not exist", simDir)}
+	}
+
+	return simDir, nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Runs `glurmo setup`
+func RunSetupCommand(args []string) error {
+	fs := NewCommandFlagSet("setup")
+	incremental := fs.Bool("incremental", false, "keep existing leaves and results, only creating new leaves and rewriting changed files")
+	clean := fs.Bool("clean", false, "with -incremental, remove leaves no longer in the grid and the outputs of changed simulations")
+	yes := fs.Bool("yes", false, "overwrite existing contents without asking, unless results or logs would be deleted")
+	force := fs.Bool("force", false, "overwrite existing contents without asking, even results and logs")
+	noClobber := fs.Bool("no-clobber", false, "never overwrite or remove existing contents, only create what is missing")
+	positional, err := ParseCommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *clean && !*incremental {
+		return usageError{"-clean can only be used with -incremental"}
+	}
+	if *noClobber && (*yes || *force || *clean) {
+		return usageError{"-no-clobber cannot be combined with -yes, -force or -clean"}
+	}
+	simDir, err := GetSimDirArg(positional)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateSimDir(simDir)
+	if err != nil {
+		return err
+	}
+	settingsMap, err := GetSettings(simDir)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not retrieve settings: %s", err)}
+	}
+
+	return SetupDir(simDir, settingsMap, SetupOptions{
+		Incremental: *incremental,
+		Clean:       *clean,
+		Yes:         *yes,
+		Force:       *force,
+		NoClobber:   *noClobber,
+	})
+}
+
+// Runs `glurmo extend`
+func RunExtendCommand(args []string) error {
+	fs := NewCommandFlagSet("extend")
+	by := fs.Int("by", 0, "number of simulations to add")
+	to := fs.Int("to", 0, "total number of simulations to extend to")
+	positional, err := ParseCommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if (*by > 0) == (*to > 0) || *by < 0 || *to < 0 {
+		return usageError{"exactly one of -by and -to must be given, as a positive integer"}
+	}
+	simDir, err := GetSimDirArg(positional)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateLeafSettings(simDir)
+	if err != nil {
+		return err
+	}
+	return ExtendSims(os.Stdout, simDir, *by, *to)
+}
+
+// Runs `glurmo run`
+func RunRunCommand(args []string) error {
+	fs := NewCommandFlagSet("run")
+	nJobs := fs.Int("n", 0, "maximum number of simulations to submit")
+	policy := fs.String("policy", PolicyDepthFirst, "how the simulations are split among simulation directories: "+strings.Join(submitPolicies, ", "))
+	perLeaf := fs.Bool("per-leaf", false, "submit up to -n simulations in every simulation directory, rather than in total")
+	maxInFlight := fs.Int("max-in-flight", 0, "submit only enough simulations for this many to be running or pending, counting those already queued")
+	positional, err := ParseCommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *nJobs < 0 || *maxInFlight < 0 || (*nJobs == 0 && *maxInFlight == 0) {
+		return usageError{"the number of jobs to submit (-n) or to keep queued (-max-in-flight) must be a positive integer"}
+	}
+	if !slices.Contains(submitPolicies, *policy) {
+		return usageError{fmt.Sprintf("the submission policy (-policy) should be one of %s - got: %s", strings.Join(submitPolicies, ", "), *policy)}
+	}
+	if *perLeaf && *policy == PolicyProportional {
+		return usageError{"-per-leaf cannot be combined with -policy proportional"}
+	}
+	simDir, err := GetSimDirArg(positional)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateLeafSettings(simDir)
+	if err != nil {
+		return err
+	}
+	sched, err := GetScheduler(simDir)
+	if err != nil {
+		return err
+	}
+	nSubmitted, err := RunJobs(os.Stdout, sched, simDir, *nJobs, RunOptions{
+		Policy:      *policy,
+		PerLeaf:     *perLeaf,
+		MaxInFlight: *maxInFlight,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Successfully submitted %d jobs\n", nSubmitted)
+
+	if waiter, isWaiter := sched.(Waiter); isWaiter && nSubmitted > 0 {
+		fmt.Println("Running them on this machine - waiting for them to finish ...")
+		err = waiter.Wait()
+		if err != nil {
+			return err
+		}
+		fmt.Println("All submitted simulations have finished")
+	}
+
+	return nil
+}
+
+// Runs `glurmo autopilot`
+func RunAutopilotCommand(args []string) error {
+	fs := NewCommandFlagSet("autopilot")
+	interval := fs.String("interval", "10m", "time between cycles, e.g. 30s, 10m or 1h")
+	nJobs := fs.Int("n", 0, "maximum number of simulations to submit per cycle")
+	maxInFlight := fs.Int("max-in-flight", 0, "maximum number of simulations to keep running or pending")
+	policy := fs.String("policy", PolicyDepthFirst, "how the simulations are split among simulation directories: "+strings.Join(submitPolicies, ", "))
+	detach := fs.Bool("detach", false, "run in the background, logging to .glurmo/autopilot.log")
+	stop := fs.Bool("stop", false, "stop the autopilot running for <sim dir>")
+	positional, err := ParseCommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	simDir, err := GetSimDirArg(positional)
+	if err != nil {
+		return err
+	}
+
+	if *stop {
+		pid, err := StopAutopilot(simDir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Stopped autopilot (pid %d)\n", pid)
+		return nil
+	}
+
+	state, found, err := LoadAutopilotState(simDir)
+	if err != nil {
+		return err
+	}
+	if !found || state.Done {
+		state = AutopilotState{Started: time.Now()}
+	}
+	// Options that are not given are those of the autopilot being resumed
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if !found || state.Done || given["interval"] {
+		state.Options.Interval = *interval
+	}
+	if !found || state.Done || given["n"] {
+		state.Options.NJobs = *nJobs
+	}
+	if !found || state.Done || given["max-in-flight"] {
+		state.Options.MaxInFlight = *maxInFlight
+	}
+	if !found || state.Done || given["policy"] {
+		state.Options.Policy = *policy
+	}
+
+	options := state.Options
+	if options.NJobs < 0 || options.MaxInFlight < 0 || (options.NJobs == 0 && options.MaxInFlight == 0) {
+		return usageError{"the number of jobs to submit per cycle (-n) or to keep queued (-max-in-flight) must be a positive integer"}
+	}
+	if !slices.Contains(submitPolicies, options.Policy) {
+		return usageError{fmt.Sprintf("the submission policy (-policy) should be one of %s - got: %s", strings.Join(submitPolicies, ", "), options.Policy)}
+	}
+	if _, err := time.ParseDuration(options.Interval); err != nil {
+		return usageError{fmt.Sprintf("the interval between cycles (-interval) should be a duration, e.g. 10m - got: %s", options.Interval)}
+	}
+
+	err = ValidateLeafSettings(simDir)
+	if err != nil {
+		return err
+	}
+
+	if *detach {
+		pid, err := DetachAutopilot(simDir, options)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Started autopilot (pid %d), logging to %s\n", pid, AutopilotLogPath(simDir))
+		return nil
+	}
+	sched, err := GetScheduler(simDir)
+	if err != nil {
+		return err
+	}
+	return RunAutopilot(os.Stdout, sched, simDir, &state)
+}
+
+// Runs `glurmo cancel`
+func RunCancelCommand(args []string) error {
+	fs := NewCommandFlagSet("cancel")
+	nJobs := fs.Int("n", 0, "maximum number of jobs to cancel")
+	states := fs.String("state", "PENDING", "comma separated states of jobs to cancel: RUNNING, PENDING, or RUNNING,PENDING")
+	positional, err := ParseCommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *nJobs <= 0 {
+		return usageError{"the number of jobs to cancel (-n) must be a positive integer"}
+	}
+	stateMap, err := ParseCancelStates(*states)
+	if err != nil {
+		return err
+	}
+	simDir, err := GetSimDirArg(positional)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateLeafSettings(simDir)
+	if err != nil {
+		return err
+	}
+	sched, err := GetScheduler(simDir)
+	if err != nil {
+		return err
+	}
+	nCancelled, err := CancelJobs(sched, simDir, *nJobs, stateMap)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Successfully cancelled %d jobs\n", nCancelled)
+
+	return nil
+}
+
+// Runs `glurmo status`
+func RunStatusCommand(args []string) error {
+	fs := NewCommandFlagSet("status")
+	positional, err := ParseCommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	simDir, err := GetSimDirArg(positional)
+	if err != nil {
+		return err
+	}
+
+	sched, err := GetScheduler(simDir)
+	if err != nil {
+		return err
+	}
+	statuses, err := CheckSimStatus(sched, simDir)
+	if err != nil {
+		return err
+	}
+	PrintSimStatus(os.Stdout, simDir, statuses)
+
+	return nil
+}
+
+// Runs `glurmo lint`
+func RunLintCommand(args []string) error {
+	fs := NewCommandFlagSet("lint")
+	positional, err := ParseCommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	simDir, err := GetSimDirArg(positional)
+	if err != nil {
+		return err
+	}
+
+	issues, err := LintSimDir(simDir)
+	if err != nil {
+		return err
+	}
+	PrintLintIssues(os.Stdout, simDir, issues)
+
+	nErrors := 0
+	for _, issue := range issues {
+		if !issue.Warning {
+			nErrors++
+		}
+	}
+	if nErrors > 0 {
+		return errorString{fmt.Sprintf("found %d errors and %d warnings", nErrors, len(issues)-nErrors)}
+	}
+	if len(issues) == 0 {
+		fmt.Println("No problems found")
+	}
+
+	return nil
+}
+
+// Runs `glurmo render`
+func RunRenderCommand(args []string) error {
+	fs := NewCommandFlagSet("render")
+	index := fs.Int("index", 0, "index of the simulation to render")
+	leaf := fs.String("leaf", "", "leaf directory to render, relative to <sim dir> (e.g. n_100/p_10)")
+	positional, err := ParseCommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	simDir, err := GetSimDirArg(positional)
+	if err != nil {
+		return err
+	}
+
+	target, err := GetRenderTarget(simDir, *leaf)
+	if err != nil {
+		return err
+	}
+	rendered, err := RenderSimulation(target, *index)
+	if err != nil {
+		return err
+	}
+	PrintRenderedSimulation(os.Stdout, rendered)
+
+	return nil
+}
+
+// Runs `glurmo help`
+func RunHelpCommand(args []string) error {
+	fs := NewCommandFlagSet("help")
+	positional, err := ParseCommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		PrintUsage(os.Stdout)
+		return nil
+	}
+	if len(positional) > 1 {
+		return usageError{"help takes at most one command"}
+	}
+
+	cmd, found := FindCommand(positional[0])
+	if !found {
+		return usageError{fmt.Sprintf("unknown command `%s`", positional[0])}
+	}
+	// help has no flags of its own to show, so running it with -h
+	// would ask for help about a command called -h
+	if cmd.Name == "help" {
+		PrintCommandHelp(os.Stdout, fs)
+		return nil
+	}
+
+	return cmd.Run([]string{"-h"})
+}
+
+// Parses a comma separated list of job states to cancel into a
+// set. Only `RUNNING` and `PENDING` are allowed.
+func ParseCancelStates(states string) (map[string]bool, error) {
+	stateMap := make(map[string]bool, 2)
+	for _, state := range strings.Split(strings.ToUpper(states), ",") {
+		state = strings.TrimSpace(state)
+		if state != JobRunning && state != JobPending {
+			return nil, usageError{fmt.Sprintf("cancellation states should be `RUNNING`, `PENDING`, or both (`RUNNING,PENDING`) - got: %s", states)}
+		}
+		stateMap[state] = true
+	}
+
+	return stateMap, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of the JobStatus attribute of HTCondor jobs
+const (
+	condorIdle               = 1
+	condorRunning            = 2
+	condorRemoved            = 3
+	condorCompleted          = 4
+	condorHeld               = 5
+	condorTransferringOutput = 6
+	condorSuspended          = 7
+)
+
+// The job attributes glurmo asks condor_q and condor_history for
+const condorJobAttributes = "ClusterId,ProcId,JobBatchName,GlurmoIndex,JobStatus,ExitCode,ExitBySignal"
+
+// The HTCondor workload manager, driven through its command line tools.
+// Every simulation is submitted with a submit description written to
+// the condor subdirectory of its simulation directory, rendered from
+// .glurmo/condor_template if there is one, or else generated to run the
+// slurm file with the resources its #SBATCH directives request. Setup
+// treats the descriptions like slurm output, since they are written
+// when simulations are submitted. The job batch name is the simulation
+// name and the GlurmoIndex attribute is the simulation index, which
+// together make up the job name glurmo expects.
+type CondorScheduler struct{}
+
+// A job ad as reported by `condor_q -json` or `condor_history -json`
+type condorJob struct {
+	ClusterID    int    `json:"ClusterId"`
+	ProcID       int    `json:"ProcId"`
+	JobBatchName string `json:"JobBatchName"`
+	GlurmoIndex  *int   `json:"GlurmoIndex"`
+	JobStatus    int    `json:"JobStatus"`
+	ExitCode     *int   `json:"ExitCode"`
+	ExitBySignal bool   `json:"ExitBySignal"`
+}
+
+// Returns the id of `job`, of the format [cluster].[proc]
+func (job condorJob) ID() string {
+	return strconv.Itoa(job.ClusterID) + "." + strconv.Itoa(job.ProcID)
+}
+
+// Returns the glurmo job name of `job`, of the format [simulation
+// name]___[job number]. Jobs not submitted by glurmo have no
+// GlurmoIndex and keep their batch name.
+func (job condorJob) JobName() string {
+	if job.GlurmoIndex == nil {
+		return job.JobBatchName
+	}
+	return job.JobBatchName + "___" + strconv.Itoa(*job.GlurmoIndex)
+}
+
+// Writes the submit description of `spec` and submits it with
+// condor_submit
+func (CondorScheduler) Submit(spec JobSpec) (string, error) {
+	description, err := CondorSubmitDescription(spec)
+	if err != nil {
+		return "", err
+	}
+	submitPath := CondorSubmitPath(spec)
+	err = os.MkdirAll(filepath.Dir(submitPath), 0755)
+	if err == nil {
+		err = os.WriteFile(submitPath, []byte(description), 0644)
+	}
+	if err != nil {
+		return "", errorString{fmt.Sprintf("could not write submit description: %s", err)}
+	}
+
+	res, err := CommandString("condor_submit",
+		"-batch-name", spec.SimID,
+		"-append", "+GlurmoIndex = "+strconv.Itoa(spec.Index),
+		submitPath)
+	if err != nil {
+		return "", err
+	}
+	_, cluster, submitted := strings.Cut(res, "submitted to cluster ")
+	cluster = strings.TrimSuffix(strings.TrimSpace(cluster), ".")
+	if _, err := strconv.Atoi(cluster); !submitted || err != nil {
+		return "", errorString{fmt.Sprintf("unexpected output from condor_submit: %s", res)}
+	}
+	return cluster + ".0", nil
+}
+
+// Returns the path the submit description of `spec` is written to
+func CondorSubmitPath(spec JobSpec) string {
+	return filepath.Join(spec.Dir, "condor", "condor_"+strconv.Itoa(spec.Index)+".sub")
+}
+
+// Returns the jobs of the current user that are in the HTCondor queue.
+// Held jobs count as pending, since they wait to be released.
+func (CondorScheduler) List() ([]Job, error) {
+	condorJobs, err := CondorJobs("condor_q", "-json", "-attributes", condorJobAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]Job, 0, len(condorJobs))
+	for _, condorJob := range condorJobs {
+		switch condorJob.JobStatus {
+		case condorIdle, condorHeld:
+			jobs = append(jobs, Job{condorJob.ID(), condorJob.JobName(), JobPending})
+		case condorRunning, condorTransferringOutput, condorSuspended:
+			jobs = append(jobs, Job{condorJob.ID(), condorJob.JobName(), JobRunning})
+		}
+	}
+	return jobs, nil
+}
+
+// Cancels `job` with condor_rm
+func (CondorScheduler) Cancel(job Job) error {
+	_, err := CommandString("condor_rm", job.ID)
+	return err
+}
+
+// Returns the jobs of the current user that condor_history has
+// recorded as finished since `since`
+func (CondorScheduler) History(since time.Time) ([]Job, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("could not get the current user: %s", err)}
+	}
+	condorJobs, err := CondorJobs("condor_history", "-json", "-attributes", condorJobAttributes,
+		"-constraint", fmt.Sprintf("EnteredCurrentStatus >= %d", since.Unix()),
+		currentUser.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]Job, 0, len(condorJobs))
+	for _, condorJob := range condorJobs {
+		jobs = append(jobs, Job{condorJob.ID(), condorJob.JobName(), CondorFinalState(condorJob)})
+	}
+	return jobs, nil
+}
+
+// Runs `command` with `args` and parses the job ads it prints as JSON.
+// HTCondor prints nothing at all when there are no jobs.
+func CondorJobs(command string, args ...string) ([]condorJob, error) {
+	raw, err := CommandString(command, args...)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]condorJob, 0)
+	if strings.TrimSpace(raw) == "" {
+		return jobs, nil
+	}
+	err = json.Unmarshal([]byte(raw), &jobs)
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("unexpected output from %s: %s", command, err)}
+	}
+	return jobs, nil
+}
+
+// Returns the slurm state that best describes how the finished HTCondor
+// job `job` ended
+func CondorFinalState(job condorJob) string {
+	switch {
+	case job.JobStatus == condorRemoved:
+		return "CANCELLED"
+	case job.ExitBySignal || job.ExitCode == nil || *job.ExitCode != 0:
+		return "FAILED"
+	}
+	return "COMPLETED"
+}
+
+// Returns the submit description of `spec`: .glurmo/condor_template of
+// its simulation directory rendered with the data of its slurm file, or
+// if there is no such template, a description generated from the slurm
+// file
+func CondorSubmitDescription(spec JobSpec) (string, error) {
+	hasCondorTemplate, err := FileExists(filepath.Join(spec.Dir, ".glurmo", "condor_template"))
+	if err != nil {
+		return "", errorString{fmt.Sprintf("could not get condor template: %s", err)}
+	}
+	if !hasCondorTemplate {
+		return GenerateCondorSubmitDescription(spec)
+	}
+
+	condorTemplate, err := GetCondorTemplate(spec.Dir, nil)
+	if err != nil {
+		return "", errorString{fmt.Sprintf("could not get condor template: %s", err)}
+	}
+	condorTemplate.Option("missingkey=error")
+	settingsMap, err := GetSettings(spec.Dir)
+	if err != nil {
+		return "", errorString{fmt.Sprintf("could not retrieve settings: %s", err)}
+	}
+	condorData := SlurmTemplateData(spec.Dir, settingsMap.Templates, settingsMap.General, spec.Index)
+	description, err := RenderTemplate(&condorTemplate, condorData)
+	if err != nil {
+		return "", errorString{fmt.Sprintf("could not populate condor template: %s", err)}
+	}
+	return description, nil
+}
+
+// Returns a submit description that runs the slurm file of `spec` in
+// its simulation directory, with its output and errors going where
+// slurm would write them, and requesting the cpus, memory, gpus and
+// time its #SBATCH directives request
+func GenerateCondorSubmitDescription(spec JobSpec) (string, error) {
+	contents, err := os.ReadFile(spec.SlurmPath())
+	if err != nil {
+		return "", errorString{fmt.Sprintf("could not read slurm file: %s", err)}
+	}
+	directives, _, err := ParseSlurmArgs(SbatchDirectives(string(contents)), sbatchOptions, false)
+	if err != nil {
+		return "", errorString{fmt.Sprintf("could not parse #SBATCH directives of %s: %s", spec.SlurmPath(), err)}
+	}
+
+	indexString := strconv.Itoa(spec.Index)
+	var description strings.Builder
+	fmt.Fprintf(&description, "# Generated by glurmo from %s\n", spec.SlurmPath())
+	fmt.Fprintf(&description, "universe = vanilla\n")
+	fmt.Fprintf(&description, "executable = %s\n", spec.SlurmPath())
+	fmt.Fprintf(&description, "initialdir = %s\n", spec.Dir)
+	fmt.Fprintf(&description, "output = %s\n", filepath.Join(spec.Dir, "slurm_out", "output___"+indexString))
+	fmt.Fprintf(&description, "error = %s\n", filepath.Join(spec.Dir, "slurm_errors", "error___"+indexString))
+	fmt.Fprintf(&description, "getenv = true\n")
+	if cpus, hasKey := directives["cpus-per-task"]; hasKey {
+		fmt.Fprintf(&description, "request_cpus = %s\n", cpus)
+	}
+	// Both default to megabytes and take the same K, M, G and T suffixes
+	if memory, hasKey := directives["mem"]; hasKey {
+		fmt.Fprintf(&description, "request_memory = %s\n", memory)
+	}
+	if gpus := SbatchGPUs(directives); gpus != "" {
+		fmt.Fprintf(&description, "request_gpus = %s\n", gpus)
+	}
+	if limit, hasKey := directives["time"]; hasKey {
+		duration, err := ParseSlurmTime(limit)
+		if err != nil {
+			return "", errorString{fmt.Sprintf("invalid time limit in %s: %s", spec.SlurmPath(), err)}
+		}
+		if duration > 0 {
+			fmt.Fprintf(&description, "allowed_execute_duration = %d\n", int(duration.Seconds()))
+		}
+	}
+	fmt.Fprintf(&description, "queue\n")
+	return description.String(), nil
+}
+
+// Returns the number of gpus requested by sbatch options `directives`,
+// either with --gpus=[type:]N or --gres=gpu[:type][:N], or "" if none
+// are
+func SbatchGPUs(directives map[string]string) string {
+	gpus, hasKey := directives["gpus"]
+	if !hasKey {
+		for _, gres := range strings.Split(directives["gres"], ",") {
+			if gres == "gpu" || strings.HasPrefix(gres, "gpu:") {
+				gpus, hasKey = gres, true
+				break
+			}
+		}
+	}
+	if !hasKey {
+		return ""
+	}
+	count := gpus[strings.LastIndex(gpus, ":")+1:]
+	if _, err := strconv.Atoi(count); err != nil {
+		// Only a type, e.g. gpu:a100
+		return "1"
+	}
+	return count
+}
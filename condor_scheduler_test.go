@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetupKeepsCondorSubmitDescriptions(t *testing.T) {
+	simDir := setupTestGrid(t)
+	settingsMap, err := GetSettings(simDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitPath := CondorSubmitPath(JobSpec{Dir: filepath.Join(simDir, "n_1"), Index: 0})
+	if err := os.MkdirAll(filepath.Dir(submitPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(submitPath, []byte("queue\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetupDir(simDir, settingsMap, SetupOptions{Incremental: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(submitPath); err != nil {
+		t.Errorf("incremental setup removed the submit description: %s", err)
+	}
+
+	// Like slurm output, submit descriptions are only deleted with -force
+	if err := SetupDir(simDir, settingsMap, SetupOptions{Yes: true}); err == nil {
+		t.Errorf("setup with -yes deleted the submit description")
+	}
+	if _, err := os.Stat(submitPath); err != nil {
+		t.Errorf("failed setup removed the submit description: %s", err)
+	}
+}
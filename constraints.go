@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A rule that removes leaves from a parameter grid, along with the
+// number of leaves it removed
+type PruneRule struct {
+	Description string
+	NPruned     int
+	excludes    func(values map[string]any) (bool, error)
+}
+
+// Returns the exclusion rules and constraints of `settingsMap` as
+// prune rules. Exclusion rules come first, so a leaf matched by
+// both is attributed to the exclusion rule.
+func GetPruneRules(settingsMap SettingsMap, axes []GridAxis) ([]PruneRule, error) {
+	// Every variable that can be referenced by a rule
+	known := make(map[string]bool, len(settingsMap.Templates))
+	for name := range settingsMap.Templates {
+		known[name] = true
+	}
+	for _, axis := range axes {
+		for _, level := range axis.Levels {
+			for name := range level.Values {
+				known[name] = true
+			}
+		}
+	}
+
+	rules := make([]PruneRule, 0, len(settingsMap.Exclude)+len(settingsMap.Constraints))
+	for _, exclusion := range settingsMap.Exclude {
+		if len(exclusion) == 0 {
+			return nil, errorString{"exclusion rules must contain at least one variable"}
+		}
+		for _, name := range SortedKeys(exclusion) {
+			if !known[name] {
+				return nil, errorString{fmt.Sprintf("exclusion rule %s refers to unknown variable '%s'",
+					FormatExclusion(exclusion), name)}
+			}
+		}
+
+		exclusion := exclusion
+		rules = append(rules, PruneRule{
+			Description: "exclusion rule " + FormatExclusion(exclusion),
+			excludes: func(values map[string]any) (bool, error) {
+				for name, value := range exclusion {
+					if FormatValue(values[name]) != FormatValue(value) {
+						return false, nil
+					}
+				}
+				return true, nil
+			},
+		})
+	}
+
+	for _, constraint := range settingsMap.Constraints {
+		expr, err := ParseExpr(constraint)
+		if err != nil {
+			return nil, errorString{fmt.Sprintf("could not parse constraint `%s`: %s", constraint, err)}
+		}
+		for _, name := range expr.Variables() {
+			if !known[name] {
+				return nil, errorString{fmt.Sprintf("constraint `%s` refers to unknown variable '%s'", constraint, name)}
+			}
+		}
+
+		constraint := constraint
+		rules = append(rules, PruneRule{
+			Description: fmt.Sprintf("constraint `%s`", constraint),
+			excludes: func(values map[string]any) (bool, error) {
+				result, err := expr.Eval(values)
+				if err != nil {
+					return false, errorString{fmt.Sprintf("could not evaluate constraint `%s`: %s", constraint, err)}
+				}
+				satisfied, isBool := result.(bool)
+				if !isBool {
+					return false, errorString{fmt.Sprintf("constraint `%s` must be true or false, got %v", constraint, result)}
+				}
+				return !satisfied, nil
+			},
+		})
+	}
+
+	return rules, nil
+}
+
+// Removes the leaves excluded by `rules` from `points`. The template
+// settings in `templates` are visible to the rules, with the values
+// of each leaf taking precedence. Every removed leaf is counted
+// against the first rule that excludes it.
+func PruneGrid(points []GridPoint, templates map[string]any, rules []PruneRule) ([]GridPoint, error) {
+	kept := make([]GridPoint, 0, len(points))
+	for _, point := range points {
+		values := maps.Clone(templates)
+		maps.Copy(values, point.Values)
+
+		excluded := false
+		for i := range rules {
+			var err error
+			excluded, err = rules[i].excludes(values)
+			if err != nil {
+				return nil, err
+			}
+			if excluded {
+				rules[i].NPruned++
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, point)
+		}
+	}
+
+	return kept, nil
+}
+
+// Prints how many of `nCombinations` grid leaves were pruned, and
+// by which rules
+func PrintPruneReport(w io.Writer, nCombinations int, nKept int, rules []PruneRule) {
+	fmt.Fprintf(w, "Pruned %d of %d combinations:\n", nCombinations-nKept, nCombinations)
+	for _, rule := range rules {
+		fmt.Fprintf(w, "  %d excluded by %s\n", rule.NPruned, rule.Description)
+	}
+}
+
+// Formats an exclusion rule as `{a: 1, b: 2}`
+func FormatExclusion(exclusion map[string]any) string {
+	parts := make([]string, 0, len(exclusion))
+	for _, name := range SortedKeys(exclusion) {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, FormatValue(exclusion[name])))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// A parsed constraint expression. Constraint expressions support
+// variables, numbers, quoted strings, `true` and `false`, the
+// arithmetic operators + - * / and % (on whole numbers only), the
+// comparisons == != < <= > >=, the boolean operators && || !, and
+// parentheses. Variables whose
+// value is a number, or a string containing a number, are compared
+// as numbers; other values are compared as strings.
+type Expr interface {
+	Eval(values map[string]any) (any, error)
+	Variables() []string
+}
+
+type literalExpr struct {
+	value any
+}
+
+type variableExpr struct {
+	name string
+}
+
+type unaryExpr struct {
+	op      string
+	operand Expr
+}
+
+type binaryExpr struct {
+	op          string
+	left, right Expr
+}
+
+func (e literalExpr) Eval(map[string]any) (any, error) {
+	return e.value, nil
+}
+
+func (e literalExpr) Variables() []string {
+	return nil
+}
+
+func (e variableExpr) Eval(values map[string]any) (any, error) {
+	value, found := values[e.name]
+	if !found {
+		return nil, errorString{fmt.Sprintf("unknown variable '%s'", e.name)}
+	}
+
+	switch v := value.(type) {
+	case int64:
+		return float64(v), nil
+	case float64, bool:
+		return v, nil
+	case string:
+		if number, err := strconv.ParseFloat(v, 64); err == nil {
+			return number, nil
+		}
+		return v, nil
+	}
+	return FormatValue(value), nil
+}
+
+func (e variableExpr) Variables() []string {
+	return []string{e.name}
+}
+
+func (e unaryExpr) Eval(values map[string]any) (any, error) {
+	operand, err := e.operand.Eval(values)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "!":
+		b, isBool := operand.(bool)
+		if !isBool {
+			return nil, errorString{fmt.Sprintf("cannot apply ! to %v", operand)}
+		}
+		return !b, nil
+	default:
+		x, isNumber := operand.(float64)
+		if !isNumber {
+			return nil, errorString{fmt.Sprintf("cannot negate %v", operand)}
+		}
+		return -x, nil
+	}
+}
+
+func (e unaryExpr) Variables() []string {
+	return e.operand.Variables()
+}
+
+func (e binaryExpr) Eval(values map[string]any) (any, error) {
+	left, err := e.left.Eval(values)
+	if err != nil {
+		return nil, err
+	}
+
+	// Boolean operators short circuit
+	if e.op == "&&" || e.op == "||" {
+		l, isBool := left.(bool)
+		if !isBool {
+			return nil, errorString{fmt.Sprintf("cannot apply %s to %v", e.op, left)}
+		}
+		if (e.op == "&&" && !l) || (e.op == "||" && l) {
+			return l, nil
+		}
+		right, err := e.right.Eval(values)
+		if err != nil {
+			return nil, err
+		}
+		r, isBool := right.(bool)
+		if !isBool {
+			return nil, errorString{fmt.Sprintf("cannot apply %s to %v", e.op, right)}
+		}
+		return r, nil
+	}
+
+	right, err := e.right.Eval(values)
+	if err != nil {
+		return nil, err
+	}
+
+	l, leftIsNumber := left.(float64)
+	r, rightIsNumber := right.(float64)
+	if leftIsNumber && rightIsNumber {
+		switch e.op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			return l / r, nil
+		case "%":
+			if l != math.Trunc(l) || r != math.Trunc(r) {
+				return nil, errorString{fmt.Sprintf("cannot apply %% to %v and %v, which must be whole numbers", left, right)}
+			}
+			if r == 0 {
+				return nil, errorString{"modulo by zero"}
+			}
+			return math.Mod(l, r), nil
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	}
+
+	switch e.op {
+	case "==":
+		return fmt.Sprint(left) == fmt.Sprint(right), nil
+	case "!=":
+		return fmt.Sprint(left) != fmt.Sprint(right), nil
+	}
+
+	ls, leftIsString := left.(string)
+	rs, rightIsString := right.(string)
+	if leftIsString && rightIsString {
+		switch e.op {
+		case "<":
+			return ls < rs, nil
+		case "<=":
+			return ls <= rs, nil
+		case ">":
+			return ls > rs, nil
+		case ">=":
+			return ls >= rs, nil
+		}
+	}
+
+	return nil, errorString{fmt.Sprintf("cannot apply %s to %v and %v", e.op, left, right)}
+}
+
+func (e binaryExpr) Variables() []string {
+	return append(e.left.Variables(), e.right.Variables()...)
+}
+
+// Binary operators from lowest to highest precedence
+var binaryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// A recursive descent parser for constraint expressions
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+// Parses the constraint expression `s`
+func ParseExpr(s string) (Expr, error) {
+	tokens, err := TokenizeExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errorString{"empty expression"}
+	}
+
+	p := &exprParser{tokens: tokens}
+	expr, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errorString{fmt.Sprintf("unexpected `%s`", p.tokens[p.pos])}
+	}
+
+	return expr, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) parseBinary(level int) (Expr, error) {
+	if level == len(binaryPrecedence) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		isOp := false
+		for _, candidate := range binaryPrecedence[level] {
+			isOp = isOp || op == candidate
+		}
+		if !isOp {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (Expr, error) {
+	token := p.peek()
+	if token == "" {
+		return nil, errorString{"unexpected end of expression"}
+	}
+	p.pos++
+
+	switch {
+	case token == "!" || token == "-":
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: token, operand: operand}, nil
+	case token == "(":
+		expr, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errorString{"missing `)`"}
+		}
+		p.pos++
+		return expr, nil
+	case token == "true" || token == "false":
+		return literalExpr{token == "true"}, nil
+	case token[0] == '"' || token[0] == '\'':
+		return literalExpr{token[1 : len(token)-1]}, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		number, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, errorString{fmt.Sprintf("invalid number `%s`", token)}
+		}
+		return literalExpr{number}, nil
+	case token[0] == '_' || unicode.IsLetter(rune(token[0])):
+		return variableExpr{token}, nil
+	}
+
+	return nil, errorString{fmt.Sprintf("unexpected `%s`", token)}
+}
+
+// Splits a constraint expression into tokens
+func TokenizeExpr(s string) ([]string, error) {
+	tokens := make([]string, 0, len(s)/2)
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end < 0 {
+				return nil, errorString{"unterminated string"}
+			}
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.' || s[j] == 'e' ||
+				((s[j] == '-' || s[j] == '+') && s[j-1] == 'e')) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			if i+1 < len(s) && slices.Contains([]string{"||", "&&", "==", "!=", "<=", ">="}, s[i:i+2]) {
+				tokens = append(tokens, s[i:i+2])
+				i += 2
+			} else if strings.ContainsRune("+-*/%<>!()", c) {
+				tokens = append(tokens, s[i:i+1])
+				i++
+			} else {
+				return nil, errorString{fmt.Sprintf("unexpected character `%c`", c)}
+			}
+		}
+	}
+
+	return tokens, nil
+}
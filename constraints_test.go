@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvalExpr(t *testing.T) {
+	values := map[string]any{
+		"n":      int64(100),
+		"p":      2.5,
+		"k":      "3",
+		"method": "lasso",
+		"flag":   true,
+	}
+	cases := []struct {
+		expr string
+		want any
+	}{
+		// Precedence
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"10 - 4 - 3", 3.0},
+		{"12 / 3 / 2", 2.0},
+		{"1 + 2 < 4", true},
+		{"1 < 2 == true", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"n % 7 + 1", 3.0},
+		// Unary minus and negation
+		{"-3 + 5", 2.0},
+		{"- -3", 3.0},
+		{"-n < 0", true},
+		{"2 * -p", -5.0},
+		{"!flag", false},
+		{"!(n > 10)", false},
+		// Strings and numbers
+		{"method == 'lasso'", true},
+		{`method != "ridge"`, true},
+		{"k == 3", true},
+		{"k == '3'", true},
+		{"n == '100'", true},
+		{"method < 'ridge'", true},
+		{"n >= p * 40", true},
+		{"1e2 == n", true},
+	}
+	for _, c := range cases {
+		expr, err := ParseExpr(c.expr)
+		if err != nil {
+			t.Errorf("ParseExpr(%q) returned error: %s", c.expr, err)
+			continue
+		}
+		got, err := expr.Eval(values)
+		if err != nil {
+			t.Errorf("Eval(%q) returned error: %s", c.expr, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("Eval(%q) = %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestEvalExprShortCircuit(t *testing.T) {
+	// `missing` would fail to evaluate, so these only succeed if the
+	// right hand side is skipped
+	for expr, want := range map[string]bool{
+		"false && missing > 1": false,
+		"true || missing > 1":  true,
+		"n < 10 && 1 % 0 == 0": false,
+	} {
+		parsed, err := ParseExpr(expr)
+		if err != nil {
+			t.Fatalf("ParseExpr(%q) returned error: %s", expr, err)
+		}
+		got, err := parsed.Eval(map[string]any{"n": int64(100)})
+		if err != nil {
+			t.Errorf("Eval(%q) returned error: %s", expr, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Eval(%q) = %v, want %v", expr, got, want)
+		}
+	}
+
+	parsed, _ := ParseExpr("true && missing > 1")
+	if _, err := parsed.Eval(map[string]any{}); err == nil {
+		t.Error("Eval(`true && missing > 1`) should fail on the unknown variable")
+	}
+}
+
+func TestParseExprMalformed(t *testing.T) {
+	cases := map[string]string{
+		"":           "empty expression",
+		"n >":        "unexpected end of expression",
+		"(n > 1":     "missing `)`",
+		"n > 1)":     "unexpected `)`",
+		"n = 1":      "unexpected character `=`",
+		"'lasso":     "unterminated string",
+		"1.2.3 > n":  "invalid number `1.2.3`",
+		"n > 1 2":    "unexpected `2`",
+		"&& n":       "unexpected `&&`",
+		"method $ 1": "unexpected character `$`",
+		"* 2":        "unexpected `*`",
+		"n == 1 ==":  "unexpected end of expression",
+	}
+	for expr, want := range cases {
+		_, err := ParseExpr(expr)
+		if err == nil {
+			t.Errorf("ParseExpr(%q) should have failed", expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseExpr(%q) failed with %q, want %q", expr, err, want)
+		}
+	}
+}
+
+func TestEvalExprErrors(t *testing.T) {
+	values := map[string]any{"n": int64(100), "p": 2.5, "method": "lasso"}
+	cases := map[string]string{
+		"n % p == 0":  "must be whole numbers",
+		"n % 0":       "modulo by zero",
+		"-method":     "cannot negate",
+		"!n":          "cannot apply !",
+		"n && true":   "cannot apply &&",
+		"method + 1":  "cannot apply +",
+		"unknown > 1": "unknown variable 'unknown'",
+		"method > 1":  "cannot apply >",
+	}
+	for expr, want := range cases {
+		parsed, err := ParseExpr(expr)
+		if err != nil {
+			t.Errorf("ParseExpr(%q) returned error: %s", expr, err)
+			continue
+		}
+		_, err = parsed.Eval(values)
+		if err == nil {
+			t.Errorf("Eval(%q) should have failed", expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Eval(%q) failed with %q, want %q", expr, err, want)
+		}
+	}
+}
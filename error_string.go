@@ -8,3 +8,13 @@ type errorString struct {
 func (e errorString) Error() string {
 	return e.s
 }
+
+// An error caused by a malformed command line, e.g. a missing
+// argument or an unknown flag.
+type usageError struct {
+	s string
+}
+
+func (e usageError) Error() string {
+	return e.s
+}
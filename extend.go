@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// A simulation directory whose `n_sims` an extension changes
+type extension struct {
+	dir  string
+	from int
+	to   int
+	t    *Transaction
+}
+
+// Increases `n_sims` of every simulation directory at or below
+// `simDir`, either by `by` or, if `to` is positive, to `to`. Only the
+// scripts and slurm files of the new indices are rendered, and the
+// stored settings are updated so that the new simulations are run
+// like any other. If `simDir` is the root of a grid, the `n_sims` of
+// its settings is increased as well, so that setting it up again keeps
+// the new simulations. All leaves are staged before any is changed, and
+// either all of them are extended or none is.
+func ExtendSims(w io.Writer, simDir string, by int, to int) error {
+	leafDirs, err := GetLeafDirs(simDir)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not find simulations in %s: %s", simDir, err)}
+	}
+	if len(leafDirs) == 0 {
+		return errorString{fmt.Sprintf("%s has no simulations that have been set up", simDir)}
+	}
+
+	extensions := make([]extension, 0, len(leafDirs))
+	discard := func() {
+		for _, e := range extensions {
+			e.t.Discard()
+		}
+	}
+	for _, leafDir := range leafDirs {
+		e, err := StageExtension(leafDir, by, to, true)
+		if err != nil {
+			discard()
+			return err
+		}
+		if e.t == nil {
+			fmt.Fprintf(w, "%s already has %d simulations\n", leafDir, e.from)
+			continue
+		}
+		extensions = append(extensions, e)
+	}
+
+	rootExtended := !slices.Contains(leafDirs, simDir) && DirHasSettings(simDir)
+	if rootExtended {
+		root, err := StageExtension(simDir, by, to, false)
+		if err != nil {
+			discard()
+			return err
+		}
+		if root.t != nil {
+			extensions = append(extensions, root)
+		}
+	}
+
+	// If any directory cannot be extended, those already extended are
+	// restored, so either every directory is extended or none is
+	for i, e := range extensions {
+		err = e.t.Apply()
+		if err != nil {
+			for _, remaining := range extensions[i+1:] {
+				remaining.t.Discard()
+			}
+			var undoErr error
+			for j := i - 1; j >= 0; j-- {
+				if err := extensions[j].t.Undo(); err != nil && undoErr == nil {
+					undoErr = err
+				}
+			}
+			if undoErr != nil {
+				return errorString{fmt.Sprintf("%s, and %s", err, undoErr)}
+			}
+			return err
+		}
+	}
+	for _, e := range extensions {
+		e.t.Discard()
+		fmt.Fprintf(w, "Extended %s from %d to %d simulations\n", e.dir, e.from, e.to)
+	}
+
+	if !rootExtended {
+		if root, found := FindGridRoot(simDir); found {
+			fmt.Fprintf(w, "WARNING: n_sims in the settings of %s was not changed - setting it up again will remove the new simulations of %s\n",
+				root, simDir)
+		}
+	}
+	return nil
+}
+
+// Stages extending the simulation directory `leafDir` by `by`
+// simulations, or to `to` if it is positive. If `render` is set, the
+// scripts and slurm files of the new simulations are staged too. If
+// `leafDir` already has the requested number of simulations, the
+// returned extension has no transaction. Grid roots are extended with
+// `render` unset, since they have no simulations themselves.
+func StageExtension(leafDir string, by int, to int, render bool) (extension, error) {
+	e := extension{dir: leafDir}
+	settingsMap, err := GetSettings(leafDir)
+	if err != nil {
+		return e, errorString{fmt.Sprintf("could not retrieve settings of %s: %s", leafDir, err)}
+	}
+	e.from, err = settingsMap.NSims()
+	if err != nil {
+		return e, errorString{fmt.Sprintf("could not extend %s: %s", leafDir, err)}
+	}
+	e.to = e.from + by
+	if to > 0 {
+		e.to = to
+	}
+	if e.to < e.from {
+		return e, errorString{fmt.Sprintf("%s already has %d simulations, more than %d - extend cannot remove simulations",
+			leafDir, e.from, e.to)}
+	}
+	if e.to == e.from {
+		return e, nil
+	}
+
+	e.t, err = NewTransaction(leafDir)
+	if err != nil {
+		return e, err
+	}
+	settingsMap.General["n_sims"] = int64(e.to)
+	err = StageNSims(e.t, leafDir, settingsMap)
+	if err == nil && render {
+		target := RenderTarget{TemplateDir: leafDir, SimDir: leafDir, Settings: settingsMap}
+		for i := e.from; i < e.to && err == nil; i++ {
+			err = StageRenderedSimulation(e.t, target, i)
+		}
+	}
+	if err != nil {
+		e.t.Discard()
+		return e, err
+	}
+	return e, nil
+}
+
+// Stages the script and slurm file of simulation `index` of `target`
+func StageRenderedSimulation(t *Transaction, target RenderTarget, index int) error {
+	rendered, err := RenderSimulation(target, index)
+	if err != nil {
+		return err
+	}
+	err = t.WriteFile(rendered.ScriptPath, []byte(rendered.Script))
+	if err != nil {
+		return err
+	}
+	return t.WriteFile(rendered.SlurmPath, []byte(rendered.Slurm))
+}
+
+// Stages changing `n_sims` in the settings file of `simDir` to that of
+// `settingsMap`. The value is replaced in place, keeping the rest of
+// the file, including comments, as it is; if that is not possible the
+// settings are written out again.
+func StageNSims(t *Transaction, simDir string, settingsMap SettingsMap) error {
+	settingsPath, err := GetSettingsFile(filepath.Join(simDir, ".glurmo"))
+	if err != nil {
+		return err
+	}
+	contents, err := os.ReadFile(settingsPath)
+	if err != nil {
+		return err
+	}
+
+	nSims, _ := settingsMap.NSims()
+	updated, replaced := ReplaceNSims(string(contents), settingsMap.FormatOrDefault(), nSims)
+	if !replaced {
+		return WriteSettings(t, simDir, settingsMap)
+	}
+	return t.WriteFile(settingsPath, []byte(updated))
+}
+
+var nSimsValueRegexp = regexp.MustCompile(`(n_sims["']?\s*[:=]\s*["']?)\d+`)
+
+// Replaces the value of `n_sims` in the "general" section of
+// `contents`, a settings file in `format`, with `nSims`. Other sections
+// may set `n_sims` too, so every candidate is checked by parsing the
+// result. Returns false if no candidate changes exactly that setting.
+func ReplaceNSims(contents string, format string, nSims int) (string, bool) {
+	original, err := ParseSettings([]byte(contents), format)
+	if err != nil {
+		return contents, false
+	}
+	otherGeneral := func(settingsMap SettingsMap) map[string]any {
+		general := make(map[string]any, len(settingsMap.General))
+		for key, value := range settingsMap.General {
+			if key != "n_sims" {
+				general[key] = value
+			}
+		}
+		return general
+	}
+
+	for _, loc := range nSimsValueRegexp.FindAllStringSubmatchIndex(contents, -1) {
+		// The number starts where the key and separator (group 1) end
+		updated := contents[:loc[3]] + strconv.Itoa(nSims) + contents[loc[1]:]
+		parsed, err := ParseSettings([]byte(updated), format)
+		if err != nil {
+			continue
+		}
+		parsedNSims, err := parsed.NSims()
+		if err == nil && parsedNSims == nSims &&
+			reflect.DeepEqual(parsed.Templates, original.Templates) &&
+			reflect.DeepEqual(otherGeneral(parsed), otherGeneral(original)) {
+			return updated, true
+		}
+	}
+	return contents, false
+}
+
+// Checks whether `dir` has a .glurmo subdirectory with a settings file
+func DirHasSettings(dir string) bool {
+	_, err := GetSettingsFile(filepath.Join(dir, ".glurmo"))
+	return err == nil
+}
+
+// Returns the closest directory above `dir` that has a settings file
+// and a design manifest, i.e. the root of the grid `dir` is part of
+func FindGridRoot(dir string) (string, bool) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for d := filepath.Dir(absDir); d != filepath.Dir(d); d = filepath.Dir(d) {
+		hasDesign, _ := FileExists(filepath.Join(d, "design.json"))
+		if hasDesign && DirHasSettings(d) {
+			return d, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Sets up a grid with leaves `n_1` and `n_2` of two simulations each
+// in a temporary directory, and returns its path
+func setupTestGrid(t *testing.T) string {
+	simDir := t.TempDir()
+	files := map[string]string{
+		"settings.json":   `{"general": {"id": "g", "n_sims": 2}, "templates": {"n": [1, 2]}}`,
+		"script_template": "echo {{.n}} > {{.results_path}}\n",
+		"slurm_template":  "#!/bin/sh\nsh {{.path_to_script}}\n",
+	}
+	if err := os.MkdirAll(filepath.Join(simDir, ".glurmo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(simDir, ".glurmo", name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	settingsMap, err := GetSettings(simDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetupDir(simDir, settingsMap, SetupOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return simDir
+}
+
+func TestExtendSims(t *testing.T) {
+	simDir := setupTestGrid(t)
+	if err := ExtendSims(io.Discard, simDir, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{simDir, filepath.Join(simDir, "n_1"), filepath.Join(simDir, "n_2")} {
+		settingsMap, err := GetSettings(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nSims, _ := settingsMap.NSims(); nSims != 3 {
+			t.Errorf("%s has n_sims %d, want 3", dir, nSims)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(simDir, "n_2", "slurm", "slurm_2")); err != nil {
+		t.Errorf("slurm file of the new simulation is missing: %s", err)
+	}
+}
+
+func TestExtendSimsAllOrNothing(t *testing.T) {
+	simDir := setupTestGrid(t)
+	// The new slurm file of n_2 cannot be written, since its slurm
+	// directory is a file
+	slurmDir := filepath.Join(simDir, "n_2", "slurm")
+	if err := os.RemoveAll(slurmDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(slurmDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ExtendSims(io.Discard, simDir, 1, 0); err == nil {
+		t.Fatal("ExtendSims should have failed")
+	}
+	for _, dir := range []string{simDir, filepath.Join(simDir, "n_1"), filepath.Join(simDir, "n_2")} {
+		settingsMap, err := GetSettings(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nSims, _ := settingsMap.NSims(); nSims != 2 {
+			t.Errorf("%s has n_sims %d after a failed extension, want 2", dir, nSims)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(simDir, "n_1", "slurm", "slurm_2")); err == nil {
+		t.Error("n_1 kept the slurm file of its new simulation after a failed extension")
+	}
+}
+
+func TestReplaceNSims(t *testing.T) {
+	cases := []struct {
+		format   string
+		contents string
+		want     string
+	}{
+		{"json",
+			"{\n\t\"templates\": {\"n_sims\": \"5\"},\n\t\"general\": {\"n_sims\": 100}\n}\n",
+			"{\n\t\"templates\": {\"n_sims\": \"5\"},\n\t\"general\": {\"n_sims\": 200}\n}\n"},
+		{"json",
+			`{"templates": {"n_sims": "5"}, "general": {"n_sims": 100}}`,
+			`{"templates": {"n_sims": "5"}, "general": {"n_sims": 200}}`},
+		{"json",
+			`{"general": {"max_n_sims": 100, "n_sims": 100}}`,
+			`{"general": {"max_n_sims": 100, "n_sims": 200}}`},
+		{"yaml",
+			"templates:\n  n_sims: 5 # not glurmo's\ngeneral:\n  n_sims: 100 # per leaf\n",
+			"templates:\n  n_sims: 5 # not glurmo's\ngeneral:\n  n_sims: 200 # per leaf\n"},
+		{"toml",
+			"[templates]\nn_sims = 5\n\n[general]\nn_sims = 100\n",
+			"[templates]\nn_sims = 5\n\n[general]\nn_sims = 200\n"},
+	}
+	for _, c := range cases {
+		got, replaced := ReplaceNSims(c.contents, c.format, 200)
+		if !replaced || got != c.want {
+			t.Errorf("ReplaceNSims(%q) = %q, %t, want %q", c.contents, got, replaced, c.want)
+		}
+	}
+
+	// Only the templates set n_sims, so the settings have to be
+	// written out again
+	if got, replaced := ReplaceNSims(`{"templates": {"n_sims": 5}, "general": {"id": "g"}}`, "json", 200); replaced {
+		t.Errorf("replaced n_sims of the templates: %q", got)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// How long finished jobs are kept in the queue of a fake slurm
+const fakeSlurmHistoryRetention = 24 * time.Hour
+
+// How often the runner of a fake slurm reads its queue for jobs to start
+const fakeSlurmPollInterval = 100 * time.Millisecond
+
+// The slurm commands a fake slurm emulates
+var fakeSlurmCommands = []string{"sbatch", "squeue", "scancel", "sacct"}
+
+// Settings of a fake slurm, kept in config.json of its directory
+type FakeSlurmConfig struct {
+	// How long jobs stay pending before they may start, e.g. "2s"
+	PendingDelay string `json:"pending_delay"`
+	// Fraction of jobs that fail without running
+	FailRate float64 `json:"fail_rate"`
+	// Fraction of jobs that are killed for hitting their time limit as
+	// soon as they start
+	TimeoutRate float64 `json:"timeout_rate"`
+	// Maximum number of jobs running at once
+	Slots int `json:"slots"`
+}
+
+// A job of a fake slurm. Its `Finished` time is when it ended, however
+// it did.
+type FakeSlurmJob struct {
+	QueuedJob
+	User    string   `json:"user"`
+	Script  string   `json:"script"`
+	Args    []string `json:"args"`
+	WorkDir string   `json:"work_dir"`
+	Output  string   `json:"output"`
+	Error   string   `json:"error"`
+	// Time limit from --time, e.g. "1:00:00", if any
+	TimeLimit string    `json:"time_limit,omitempty"`
+	Started   time.Time `json:"started"`
+}
+
+// A fake slurm cluster on the current machine, kept in directory
+// `Dir`: a queue of jobs that each wait for a while, then run for real,
+// at most `Config.Slots` at a time, with some of them made to fail or
+// time out. Its sbatch, squeue, scancel and sacct understand the
+// options glurmo uses, and print what slurm would.
+type FakeSlurm struct {
+	Dir    string
+	Config FakeSlurmConfig
+}
+
+// Opens the fake slurm installed in `dir`
+func OpenFakeSlurm(dir string) (FakeSlurm, error) {
+	f := FakeSlurm{Dir: dir, Config: DefaultFakeSlurmConfig()}
+	contents, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, errorString{fmt.Sprintf("no fake slurm is installed in %s - install one with `glurmo fakeslurm install %s`", dir, dir)}
+	}
+	if err == nil {
+		err = json.Unmarshal(contents, &f.Config)
+	}
+	if err != nil {
+		return f, errorString{fmt.Sprintf("could not read fake slurm settings in %s: %s", dir, err)}
+	}
+	return f, nil
+}
+
+// Returns the settings of a fake slurm that are not given
+func DefaultFakeSlurmConfig() FakeSlurmConfig {
+	return FakeSlurmConfig{PendingDelay: "1s", Slots: runtime.NumCPU()}
+}
+
+// Calls `update` with the queue while holding a lock on it, then saves
+// the queue. Before `update` is called, queued jobs whose runner has
+// exited, e.g. because the machine was rebooted, are marked as having
+// failed with their node. Jobs that finished too long ago for sacct to
+// bother with are dropped.
+func (f FakeSlurm) withQueue(update func(q *JobQueue[*FakeSlurmJob]) error) error {
+	return UpdateJobQueue(filepath.Join(f.Dir, "queue.json"), "fake slurm queue", "NODE_FAIL", func(q *JobQueue[*FakeSlurmJob]) error {
+		err := update(q)
+		if err != nil {
+			return err
+		}
+		q.prune(fakeSlurmHistoryRetention)
+		return nil
+	})
+}
+
+// Installs a fake slurm in `dir`: writes `config`, and sbatch, squeue,
+// scancel and sacct scripts that run the current glurmo executable
+func InstallFakeSlurm(dir string, config FakeSlurmConfig) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return errorString{fmt.Sprintf("could not install fake slurm: %s", err)}
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not install fake slurm: %s", err)}
+	}
+
+	configJSON, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return errorString{fmt.Sprintf("could not install fake slurm: %s", err)}
+	}
+	err = os.WriteFile(filepath.Join(dir, "config.json"), configJSON, 0644)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not install fake slurm: %s", err)}
+	}
+
+	for _, command := range fakeSlurmCommands {
+		shim := fmt.Sprintf("#!/bin/sh\nexec %s fakeslurm -dir %s %s \"$@\"\n",
+			ShellQuote(executable), ShellQuote(dir), command)
+		err = os.WriteFile(filepath.Join(dir, command), []byte(shim), 0755)
+		if err != nil {
+			return errorString{fmt.Sprintf("could not install fake slurm: %s", err)}
+		}
+	}
+	return nil
+}
+
+// Quotes `s` for a POSIX shell
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Queues `job`, starting the runner of the fake slurm if it is not
+// running. Returns the id of the job.
+func (f FakeSlurm) Submit(job FakeSlurmJob) (int, error) {
+	job.State = JobPending
+	job.Submitted = time.Now()
+	if current, err := user.Current(); err == nil {
+		job.User = current.Username
+	}
+	err := f.withQueue(func(q *JobQueue[*FakeSlurmJob]) error {
+		runner, err := f.startRunner()
+		if err != nil {
+			return errorString{fmt.Sprintf("sbatch: error: could not start job: %s", err)}
+		}
+		q.NextID++
+		job.ID = q.NextID
+		job.Runner = runner
+		job.Output = ExpandSlurmPattern(job.Output, job)
+		job.Error = ExpandSlurmPattern(job.Error, job)
+		q.Jobs = append(q.Jobs, &job)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return job.ID, nil
+}
+
+// Returns the pid of the runner of the fake slurm, starting one if none
+// is running. The runner holds a lock on runner.pid for as long as it
+// runs, and is handed the lock by the process that starts it, so there
+// is never more than one. This must be called with the queue locked:
+// the runner only lets go of the lock with the queue locked, once no
+// job is left queued, so a job queued here is always picked up.
+func (f FakeSlurm) startRunner() (int, error) {
+	pidPath := filepath.Join(f.Dir, "runner.pid")
+	lock, err := os.OpenFile(pidPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Close()
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		contents, err := os.ReadFile(pidPath)
+		if err != nil {
+			return 0, err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+		if err != nil {
+			return 0, errorString{fmt.Sprintf("%s is malformed", pidPath)}
+		}
+		return pid, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	runner := exec.Command(executable, "fakeslurm", "-dir", f.Dir, "run-queue")
+	// The lock is the runner's file descriptor 3
+	runner.ExtraFiles = []*os.File{lock}
+	runner.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = runner.Start()
+	if err != nil {
+		return 0, err
+	}
+	pid := runner.Process.Pid
+	runner.Process.Release()
+
+	err = lock.Truncate(0)
+	if err == nil {
+		_, err = lock.WriteAt([]byte(strconv.Itoa(pid)+"\n"), 0)
+	}
+	return pid, err
+}
+
+// Replaces the %j (job id), %x (job name) and %u (user) placeholders in
+// the output or error file name `pattern`, the way sbatch does
+func ExpandSlurmPattern(pattern string, job FakeSlurmJob) string {
+	return strings.NewReplacer(
+		"%j", strconv.Itoa(job.ID),
+		"%x", job.Name,
+		"%u", job.User,
+		"%%", "%",
+	).Replace(pattern)
+}
+
+// Runs the queue of the fake slurm until no job is left queued:
+// starts every job that has been pending for the configured delay once
+// a slot is free, and runs it in the background. `lock` is the lock on
+// runner.pid, which the runner holds until it exits. The queue is only
+// read, not locked, until there is something to do.
+func (f FakeSlurm) RunQueue(lock *os.File) error {
+	pendingDelay, err := time.ParseDuration(f.Config.PendingDelay)
+	if err != nil {
+		return errorString{fmt.Sprintf("invalid pending delay: %s", f.Config.PendingDelay)}
+	}
+	queuePath := filepath.Join(f.Dir, "queue.json")
+
+	for {
+		var q JobQueue[*FakeSlurmJob]
+		contents, err := os.ReadFile(queuePath)
+		if err == nil {
+			err = json.Unmarshal(contents, &q)
+		}
+		if err == nil && !FakeSlurmRunnerHasWork(q, pendingDelay, f.Config.Slots) {
+			time.Sleep(fakeSlurmPollInterval)
+			continue
+		}
+
+		done := false
+		started := make([]FakeSlurmJob, 0)
+		err = f.withQueue(func(q *JobQueue[*FakeSlurmJob]) error {
+			if !slices.ContainsFunc(q.Jobs, func(job *FakeSlurmJob) bool { return job.Queued() }) {
+				// With the queue locked, so that sbatch either queues
+				// its job before this or starts a new runner after
+				done = true
+				return syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+			}
+			free := f.Config.Slots - q.running()
+			for _, job := range q.Jobs {
+				if free > 0 && job.State == JobPending && time.Since(job.Submitted) >= pendingDelay {
+					job.State = JobRunning
+					job.Started = time.Now()
+					started = append(started, *job)
+					free--
+				}
+			}
+			return nil
+		})
+		if err != nil || done {
+			return err
+		}
+		for _, job := range started {
+			go f.runJob(job)
+		}
+		time.Sleep(fakeSlurmPollInterval)
+	}
+}
+
+// Checks whether the runner has something to do in queue `q`: a job it
+// can start, or no job left queued at all
+func FakeSlurmRunnerHasWork(q JobQueue[*FakeSlurmJob], pendingDelay time.Duration, slots int) bool {
+	queued := false
+	ready := false
+	for _, job := range q.Jobs {
+		queued = queued || job.Queued()
+		ready = ready || job.State == JobPending && time.Since(job.Submitted) >= pendingDelay
+	}
+	return !queued || ready && q.running() < slots
+}
+
+// Runs job `job`, which the runner has just marked as running: either
+// fails it, times it out, or runs its script, recording how it ended
+func (f FakeSlurm) runJob(job FakeSlurmJob) error {
+	id := job.ID
+	roll := rand.Float64()
+	if roll < f.Config.FailRate {
+		os.WriteFile(job.Error, []byte(fmt.Sprintf("fakeslurm: simulated failure of job %d\n", id)), 0644)
+		return f.finishJob(id, "FAILED", 1)
+	}
+	timeLimit, err := ParseSlurmTime(job.TimeLimit)
+	if err != nil {
+		return f.finishJob(id, "FAILED", 1)
+	}
+	if roll < f.Config.FailRate+f.Config.TimeoutRate {
+		timeLimit = time.Nanosecond
+	}
+
+	cmd, err := FakeSlurmCommand(job)
+	if err != nil {
+		return f.finishJob(id, "FAILED", 1)
+	}
+	defer cmd.Stdout.(*os.File).Close()
+	defer cmd.Stderr.(*os.File).Close()
+	err = cmd.Start()
+	if err != nil {
+		fmt.Fprintf(cmd.Stderr, "fakeslurm: could not run %s: %s\n", job.Script, err)
+		return f.finishJob(id, "FAILED", 1)
+	}
+
+	f.withQueue(func(q *JobQueue[*FakeSlurmJob]) error {
+		queued := q.find(id)
+		if queued == nil || queued.State != JobRunning {
+			// Cancelled while it was starting
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+			return nil
+		}
+		queued.PID = cmd.Process.Pid
+		return nil
+	})
+
+	var timedOut atomic.Bool
+	if timeLimit > 0 {
+		timer := time.AfterFunc(timeLimit, func() {
+			timedOut.Store(true)
+			fmt.Fprintf(cmd.Stderr, "slurmstepd: error: *** JOB %d ON fakeslurm CANCELLED AT %s DUE TO TIME LIMIT ***\n",
+				id, time.Now().Format("2006-01-02T15:04:05"))
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		})
+		defer timer.Stop()
+	}
+
+	err = cmd.Wait()
+	switch {
+	case timedOut.Load():
+		return f.finishJob(id, "TIMEOUT", 0)
+	case err != nil:
+		return f.finishJob(id, "FAILED", cmd.ProcessState.ExitCode())
+	default:
+		return f.finishJob(id, "COMPLETED", 0)
+	}
+}
+
+// Records that job `id` ended in `state`, unless it was cancelled
+func (f FakeSlurm) finishJob(id int, state string, exitCode int) error {
+	return f.withQueue(func(q *JobQueue[*FakeSlurmJob]) error {
+		job := q.find(id)
+		if job != nil && job.Queued() {
+			job.State = state
+			job.ExitCode = exitCode
+			job.Finished = time.Now()
+		}
+		return nil
+	})
+}
+
+// Returns the command that runs the script of `job` with the
+// interpreter of its #! line, in its own process group, with its output
+// and errors going to the job's output and error files
+func FakeSlurmCommand(job FakeSlurmJob) (*exec.Cmd, error) {
+	stdout, err := os.Create(job.Output)
+	if err != nil {
+		return nil, err
+	}
+	stderr := stdout
+	if job.Error != job.Output {
+		stderr, err = os.Create(job.Error)
+		if err != nil {
+			stdout.Close()
+			return nil, err
+		}
+	}
+
+	contents, err := os.ReadFile(job.Script)
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		return nil, err
+	}
+	firstLine, _, _ := strings.Cut(string(contents), "\n")
+	interpreter := strings.Fields(strings.TrimPrefix(firstLine, "#!"))
+	if !strings.HasPrefix(firstLine, "#!") || len(interpreter) == 0 {
+		interpreter = []string{"/bin/sh"}
+	}
+	args := append(interpreter[1:], job.Script)
+
+	cmd := exec.Command(interpreter[0], append(args, job.Args...)...)
+	cmd.Dir = job.WorkDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(),
+		"SLURM_JOB_ID="+strconv.Itoa(job.ID),
+		"SLURM_JOB_NAME="+job.Name,
+		"SLURM_SUBMIT_DIR="+job.WorkDir)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd, nil
+}
+
+// Cancels job `id`, terminating it if it is running
+func (f FakeSlurm) Cancel(id int) error {
+	return f.withQueue(func(q *JobQueue[*FakeSlurmJob]) error {
+		job := q.find(id)
+		if job == nil {
+			return errorString{fmt.Sprintf("scancel: error: Kill job error on job id %d: Invalid job id specified", id)}
+		}
+		if !job.Queued() {
+			return errorString{fmt.Sprintf("scancel: error: Kill job error on job id %d: Job/step already completing or completed", id)}
+		}
+		if job.State == JobRunning && job.PID > 0 {
+			err := syscall.Kill(-job.PID, syscall.SIGTERM)
+			if err != nil && !errors.Is(err, syscall.ESRCH) {
+				return errorString{fmt.Sprintf("scancel: error: could not kill job %d: %s", id, err)}
+			}
+		}
+		job.State = "CANCELLED"
+		job.Finished = time.Now()
+		return nil
+	})
+}
+
+// Returns every job of the fake slurm
+func (f FakeSlurm) Jobs() ([]FakeSlurmJob, error) {
+	var jobs []FakeSlurmJob
+	err := f.withQueue(func(q *JobQueue[*FakeSlurmJob]) error {
+		jobs = make([]FakeSlurmJob, 0, len(q.Jobs))
+		for _, job := range q.Jobs {
+			jobs = append(jobs, *job)
+		}
+		return nil
+	})
+	return jobs, err
+}
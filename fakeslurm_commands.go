@@ -0,0 +1,632 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Options of an emulated slurm command: the long name of every option
+// that takes a value, and the long name of every short option
+type slurmOptions struct {
+	withValue []string
+	short     map[string]string
+}
+
+var sbatchOptions = slurmOptions{
+	withValue: []string{"job-name", "output", "error", "time", "partition", "account", "qos",
+		"cpus-per-task", "ntasks", "nodes", "mem", "mem-per-cpu", "gres", "gpus", "constraint",
+		"chdir", "mail-type", "mail-user", "array", "dependency"},
+	short: map[string]string{"J": "job-name", "o": "output", "e": "error", "t": "time",
+		"p": "partition", "A": "account", "q": "qos", "c": "cpus-per-task", "n": "ntasks",
+		"N": "nodes", "G": "gpus", "C": "constraint", "D": "chdir", "a": "array", "d": "dependency"},
+}
+
+var squeueOptions = slurmOptions{
+	withValue: []string{"format", "user", "states", "jobs", "name", "sort", "partition"},
+	short: map[string]string{"o": "format", "u": "user", "t": "states", "j": "jobs",
+		"n": "name", "S": "sort", "p": "partition", "h": "noheader"},
+}
+
+var scancelOptions = slurmOptions{
+	withValue: []string{"user", "state", "name"},
+	short:     map[string]string{"u": "user", "t": "state", "n": "name"},
+}
+
+var sacctOptions = slurmOptions{
+	withValue: []string{"format", "starttime", "endtime", "state", "jobs", "user"},
+	short: map[string]string{"o": "format", "S": "starttime", "E": "endtime", "s": "state",
+		"j": "jobs", "u": "user", "n": "noheader", "p": "parsable", "P": "parsable2",
+		"X": "allocations", "a": "allusers"},
+}
+
+// Abbreviations of job states that squeue and sacct accept and print
+var slurmStateCodes = map[string]string{
+	"PD":  "PENDING",
+	"R":   "RUNNING",
+	"CD":  "COMPLETED",
+	"F":   "FAILED",
+	"CA":  "CANCELLED",
+	"TO":  "TIMEOUT",
+	"NF":  "NODE_FAIL",
+	"OOM": "OUT_OF_MEMORY",
+	"PR":  "PREEMPTED",
+}
+
+// Parses the slurm style command line `args` of an emulated command:
+// `--name=value`, `--name value`, `-n value` and `-nvalue` options, and
+// flags. Returns the options by long name, with flags set to "true",
+// and the other arguments. If `stopAtArg` is set, everything after the
+// first argument that is not an option is returned as is, the way
+// sbatch passes it on to the script.
+func ParseSlurmArgs(args []string, options slurmOptions, stopAtArg bool) (map[string]string, []string, error) {
+	values := make(map[string]string)
+	positional := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return values, append(positional, args[i+1:]...), nil
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			if stopAtArg {
+				return values, append(positional, args[i:]...), nil
+			}
+			positional = append(positional, arg)
+			continue
+		}
+
+		var name, value string
+		hasValue := false
+		if long, isLong := strings.CutPrefix(arg, "--"); isLong {
+			name, value, hasValue = strings.Cut(long, "=")
+		} else {
+			short := arg[1:2]
+			name = options.short[short]
+			if name == "" {
+				return nil, nil, errorString{fmt.Sprintf("invalid option -- '%s'", short)}
+			}
+			value, hasValue = arg[2:], len(arg) > 2
+		}
+
+		if !slices.Contains(options.withValue, name) {
+			values[name] = "true"
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return nil, nil, errorString{fmt.Sprintf("option '%s' requires an argument", arg)}
+			}
+			i++
+			value = args[i]
+		}
+		values[name] = value
+	}
+	return values, positional, nil
+}
+
+// Emulates `sbatch [options] script [args]`
+func FakeSbatch(w io.Writer, f FakeSlurm, args []string) error {
+	options, positional, err := ParseSlurmArgs(args, sbatchOptions, true)
+	if err != nil {
+		return errorString{fmt.Sprintf("sbatch: %s", err)}
+	}
+	if len(positional) == 0 {
+		return errorString{"sbatch: error: reading a batch script from standard input is not supported by fakeslurm"}
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return errorString{fmt.Sprintf("sbatch: error: %s", err)}
+	}
+	script := AbsFrom(workDir, positional[0])
+	contents, err := os.ReadFile(script)
+	if err != nil {
+		return errorString{fmt.Sprintf("sbatch: error: Unable to open file %s", positional[0])}
+	}
+	if !strings.HasPrefix(string(contents), "#!") {
+		return errorString{"sbatch: error: This does not look like a batch script.  The first line must start with #! followed by the path to an interpreter."}
+	}
+
+	// Options on the command line take precedence over #SBATCH lines
+	scriptOptions, _, err := ParseSlurmArgs(SbatchDirectives(string(contents)), sbatchOptions, false)
+	if err != nil {
+		return errorString{fmt.Sprintf("sbatch: error: in #SBATCH line of %s: %s", script, err)}
+	}
+	for name, value := range options {
+		scriptOptions[name] = value
+	}
+	options = scriptOptions
+	if _, isArray := options["array"]; isArray {
+		return errorString{"sbatch: error: job arrays are not supported by fakeslurm"}
+	}
+	if chdir, hasChdir := options["chdir"]; hasChdir {
+		workDir = AbsFrom(workDir, chdir)
+	}
+	if _, err := ParseSlurmTime(options["time"]); err != nil {
+		return errorString{"sbatch: error: Invalid --time specification"}
+	}
+
+	job := FakeSlurmJob{
+		QueuedJob: QueuedJob{Name: options["job-name"]},
+		Script:    script,
+		Args:      positional[1:],
+		WorkDir:   workDir,
+		Output:    AbsFrom(workDir, options["output"]),
+		TimeLimit: options["time"],
+	}
+	if job.Name == "" {
+		job.Name = filepath.Base(script)
+	}
+	if options["output"] == "" {
+		job.Output = filepath.Join(workDir, "slurm-%j.out")
+	}
+	job.Error = job.Output
+	if options["error"] != "" {
+		job.Error = AbsFrom(workDir, options["error"])
+	}
+
+	id, err := f.Submit(job)
+	if err != nil {
+		return err
+	}
+	if options["parsable"] == "true" {
+		fmt.Fprintln(w, id)
+	} else {
+		fmt.Fprintf(w, "Submitted batch job %d\n", id)
+	}
+	return nil
+}
+
+// Returns the options of the #SBATCH lines at the start of the batch
+// script `contents`, which end at its first line that is not a comment
+func SbatchDirectives(contents string) []string {
+	directives := make([]string, 0)
+	for _, line := range strings.Split(contents, "\n")[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		if options, isDirective := strings.CutPrefix(line, "#SBATCH"); isDirective {
+			options, _, _ = strings.Cut(options, " #")
+			directives = append(directives, strings.Fields(options)...)
+		}
+	}
+	return directives
+}
+
+// Returns `path`, relative to `dir` unless it is absolute
+func AbsFrom(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Emulates `squeue`, listing pending and running jobs
+func FakeSqueue(w io.Writer, f FakeSlurm, args []string) error {
+	options, _, err := ParseSlurmArgs(args, squeueOptions, false)
+	if err != nil {
+		return errorString{fmt.Sprintf("squeue: %s", err)}
+	}
+	format := options["format"]
+	if format == "" {
+		format = "%.18i %.9P %.8j %.8u %.2t %.10M %.6D %R"
+	}
+	states, err := ParseSlurmStates(options["states"])
+	if err != nil {
+		return errorString{fmt.Sprintf("squeue: error: %s", err)}
+	}
+
+	jobs, err := f.Jobs()
+	if err != nil {
+		return err
+	}
+	if options["noheader"] != "true" {
+		fmt.Fprintln(w, FormatSqueueLine(format, nil))
+	}
+	for _, job := range jobs {
+		if !job.Queued() || !MatchesJobFilters(job, options, states) {
+			continue
+		}
+		fmt.Fprintln(w, FormatSqueueLine(format, &job))
+	}
+	return nil
+}
+
+// Checks whether `job` has one of `states`, if any are given, and the
+// ids in the "jobs" option and the names in the "name" option, if they
+// are given
+func MatchesJobFilters(job FakeSlurmJob, options map[string]string, states []string) bool {
+	if len(states) > 0 && !slices.Contains(states, job.State) {
+		return false
+	}
+	if ids, hasIDs := options["jobs"]; hasIDs && !slices.Contains(strings.Split(ids, ","), strconv.Itoa(job.ID)) {
+		return false
+	}
+	if names, hasNames := options["name"]; hasNames && !slices.Contains(strings.Split(names, ","), job.Name) {
+		return false
+	}
+	return true
+}
+
+// Parses a comma separated list of job states, full or abbreviated
+func ParseSlurmStates(states string) ([]string, error) {
+	parsed := make([]string, 0)
+	if states == "" || strings.EqualFold(states, "all") {
+		return parsed, nil
+	}
+	for _, state := range strings.Split(strings.ToUpper(states), ",") {
+		if full, isCode := slurmStateCodes[state]; isCode {
+			state = full
+		}
+		known := false
+		for _, full := range slurmStateCodes {
+			known = known || state == full
+		}
+		if !known {
+			return nil, errorString{fmt.Sprintf("Invalid job state specified: %s", state)}
+		}
+		parsed = append(parsed, state)
+	}
+	return parsed, nil
+}
+
+// Formats `job` according to the squeue format `format`, e.g.
+// "%.18i %j %T", or the header of that format if `job` is nil. A "."
+// right-justifies a field, and a number sets its width, truncating
+// longer values.
+func FormatSqueueLine(format string, job *FakeSlurmJob) string {
+	var line strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			line.WriteByte(format[i])
+			continue
+		}
+		i++
+		rightJustify := format[i] == '.'
+		if rightJustify {
+			i++
+		}
+		start := i
+		for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			i++
+		}
+		width, _ := strconv.Atoi(format[start:i])
+		if i == len(format) {
+			break
+		}
+
+		header, value := SqueueField(format[i], job)
+		if job == nil {
+			value = header
+		}
+		line.WriteString(PadField(value, width, rightJustify))
+	}
+	return line.String()
+}
+
+// Returns the header and, if `job` is not nil, the value of squeue
+// format field `field`
+func SqueueField(field byte, job *FakeSlurmJob) (string, string) {
+	if job == nil {
+		job = &FakeSlurmJob{}
+	}
+	switch field {
+	case 'i', 'A':
+		return "JOBID", strconv.Itoa(job.ID)
+	case 'j':
+		return "NAME", job.Name
+	case 'T':
+		return "STATE", job.State
+	case 't':
+		for code, state := range slurmStateCodes {
+			if state == job.State {
+				return "ST", code
+			}
+		}
+		return "ST", job.State
+	case 'u':
+		return "USER", job.User
+	case 'M':
+		if job.State != JobRunning {
+			return "TIME", "0:00"
+		}
+		return "TIME", FormatSlurmDuration(time.Since(job.Started), false)
+	case 'l':
+		if job.TimeLimit == "" {
+			return "TIME_LIMIT", "UNLIMITED"
+		}
+		return "TIME_LIMIT", job.TimeLimit
+	case 'Z':
+		return "WORK_DIR", job.WorkDir
+	case 'o':
+		return "COMMAND", job.Script
+	case 'P':
+		return "PARTITION", "fake"
+	case 'D':
+		return "NODES", "1"
+	case 'R':
+		if job.State == JobPending {
+			return "NODELIST(REASON)", "(Resources)"
+		}
+		return "NODELIST(REASON)", "localhost"
+	}
+	return "", ""
+}
+
+// Pads `value` to `width`, on the left if `rightJustify` is set, and
+// truncates it if it is longer. A width of 0 leaves `value` as it is.
+func PadField(value string, width int, rightJustify bool) string {
+	if width == 0 {
+		return value
+	}
+	if len(value) > width {
+		return value[:width]
+	}
+	if rightJustify {
+		return fmt.Sprintf("%*s", width, value)
+	}
+	return fmt.Sprintf("%-*s", width, value)
+}
+
+// Emulates `scancel id...`
+func FakeScancel(f FakeSlurm, args []string) error {
+	_, ids, err := ParseSlurmArgs(args, scancelOptions, false)
+	if err != nil {
+		return errorString{fmt.Sprintf("scancel: %s", err)}
+	}
+	if len(ids) == 0 {
+		return errorString{"scancel: error: No job identification provided"}
+	}
+	for _, idString := range ids {
+		id, err := strconv.Atoi(idString)
+		if err != nil {
+			return errorString{fmt.Sprintf("scancel: error: Invalid job id %s", idString)}
+		}
+		err = f.Cancel(id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Emulates `sacct`, listing jobs that were queued at some point
+// between the start and end times, which default to midnight and now
+func FakeSacct(w io.Writer, f FakeSlurm, args []string) error {
+	options, _, err := ParseSlurmArgs(args, sacctOptions, false)
+	if err != nil {
+		return errorString{fmt.Sprintf("sacct: %s", err)}
+	}
+	year, month, day := time.Now().Date()
+	startTime := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	endTime := time.Now()
+	if options["starttime"] != "" {
+		startTime, err = ParseSlurmTimestamp(options["starttime"])
+		if err != nil {
+			return errorString{fmt.Sprintf("sacct: error: %s", err)}
+		}
+	}
+	if options["endtime"] != "" {
+		endTime, err = ParseSlurmTimestamp(options["endtime"])
+		if err != nil {
+			return errorString{fmt.Sprintf("sacct: error: %s", err)}
+		}
+	}
+	states, err := ParseSlurmStates(options["state"])
+	if err != nil {
+		return errorString{fmt.Sprintf("sacct: error: %s", err)}
+	}
+
+	format := options["format"]
+	if format == "" {
+		format = "JobID,JobName,State,ExitCode"
+	}
+	fields := strings.Split(format, ",")
+	widths := make([]int, len(fields))
+	for i, field := range fields {
+		name, width, hasWidth := strings.Cut(field, "%")
+		fields[i] = strings.ToLower(name)
+		widths[i] = 10
+		if hasWidth {
+			widths[i], err = strconv.Atoi(width)
+			if err != nil {
+				return errorString{fmt.Sprintf("sacct: error: Invalid field width: %s", field)}
+			}
+		}
+		if _, known := SacctField(fields[i], FakeSlurmJob{}); !known {
+			return errorString{fmt.Sprintf("sacct: error: Invalid field requested: \"%s\"", name)}
+		}
+	}
+
+	jobs, err := f.Jobs()
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(jobs)+1)
+	if options["noheader"] != "true" {
+		rows = append(rows, strings.Split(format, ","))
+	}
+	for _, job := range jobs {
+		if !job.Finished.IsZero() && job.Finished.Before(startTime) || job.Submitted.After(endTime) ||
+			!MatchesJobFilters(job, options, states) {
+			continue
+		}
+		row := make([]string, len(fields))
+		for i, field := range fields {
+			row[i], _ = SacctField(field, job)
+		}
+		rows = append(rows, row)
+	}
+
+	for i, row := range rows {
+		switch {
+		case options["parsable2"] == "true":
+			fmt.Fprintln(w, strings.Join(row, "|"))
+		case options["parsable"] == "true":
+			fmt.Fprintln(w, strings.Join(row, "|")+"|")
+		default:
+			for j, value := range row {
+				row[j] = PadField(value, widths[j], i > 0 || options["noheader"] == "true")
+			}
+			fmt.Fprintln(w, strings.Join(row, " "))
+			if i == 0 && options["noheader"] != "true" {
+				for j := range row {
+					row[j] = strings.Repeat("-", widths[j])
+				}
+				fmt.Fprintln(w, strings.Join(row, " "))
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the value of sacct format field `field`, in lower case, for
+// `job`, and whether the field is known
+func SacctField(field string, job FakeSlurmJob) (string, bool) {
+	timestamp := func(t time.Time) string {
+		if t.IsZero() {
+			return "Unknown"
+		}
+		return t.Format("2006-01-02T15:04:05")
+	}
+	switch field {
+	case "jobid", "jobidraw":
+		return strconv.Itoa(job.ID), true
+	case "jobname":
+		return job.Name, true
+	case "state":
+		return job.State, true
+	case "exitcode":
+		return fmt.Sprintf("%d:0", job.ExitCode), true
+	case "user":
+		return job.User, true
+	case "workdir":
+		return job.WorkDir, true
+	case "submit":
+		return timestamp(job.Submitted), true
+	case "start":
+		return timestamp(job.Started), true
+	case "end":
+		return timestamp(job.Finished), true
+	case "elapsed":
+		switch {
+		case job.Started.IsZero():
+			return "00:00:00", true
+		case job.Finished.IsZero():
+			return FormatSlurmDuration(time.Since(job.Started), true), true
+		default:
+			return FormatSlurmDuration(job.Finished.Sub(job.Started), true), true
+		}
+	case "timelimit":
+		if job.TimeLimit == "" {
+			return "UNLIMITED", true
+		}
+		return job.TimeLimit, true
+	}
+	return "", false
+}
+
+// Parses a timestamp as sacct accepts it: YYYY-MM-DD[THH:MM[:SS]], in
+// local time
+func ParseSlurmTimestamp(timestamp string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		t, err := time.ParseInLocation(layout, timestamp, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errorString{fmt.Sprintf("Invalid time specification: %s", timestamp)}
+}
+
+// Runs `glurmo fakeslurm`
+func RunFakeSlurmCommand(args []string) error {
+	fs := NewCommandFlagSet("fakeslurm")
+	dir := fs.String("dir", "", "directory of the fake slurm whose command to run")
+	defaults := DefaultFakeSlurmConfig()
+	pendingDelay := fs.String("pending-delay", defaults.PendingDelay, "with install, how long jobs stay pending before they may start")
+	failRate := fs.Float64("fail-rate", defaults.FailRate, "with install, fraction of jobs that fail without running")
+	timeoutRate := fs.Float64("timeout-rate", defaults.TimeoutRate, "with install, fraction of jobs that time out as soon as they start")
+	slots := fs.Int("slots", defaults.Slots, "with install, maximum number of jobs running at once")
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		PrintCommandHelp(os.Stdout, fs)
+		return err
+	}
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	if fs.NArg() == 0 {
+		return usageError{"expected install, or one of " + strings.Join(fakeSlurmCommands, ", ")}
+	}
+	command := fs.Arg(0)
+
+	if command == "install" {
+		positional, err := ParseCommandArgs(fs, fs.Args()[1:])
+		if err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return usageError{"expected the directory to install the fake slurm in"}
+		}
+		config := FakeSlurmConfig{PendingDelay: *pendingDelay, FailRate: *failRate, TimeoutRate: *timeoutRate, Slots: *slots}
+		if _, err := time.ParseDuration(config.PendingDelay); err != nil {
+			return usageError{fmt.Sprintf("the pending delay (-pending-delay) should be a duration, e.g. 2s - got: %s", config.PendingDelay)}
+		}
+		if config.FailRate < 0 || config.TimeoutRate < 0 || config.FailRate+config.TimeoutRate > 1 {
+			return usageError{"the failure and timeout rates (-fail-rate, -timeout-rate) should be between 0 and 1, and add up to at most 1"}
+		}
+		if config.Slots < 1 {
+			return usageError{"the number of slots (-slots) must be a positive integer"}
+		}
+		installDir, err := filepath.Abs(positional[0])
+		if err != nil {
+			return errorString{fmt.Sprintf("could not get absolute path to %s: %s", positional[0], err)}
+		}
+		err = InstallFakeSlurm(installDir, config)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed a fake slurm in %s - to use it instead of slurm, run\n\n  export PATH=%s:$PATH\n",
+			installDir, ShellQuote(installDir))
+		return nil
+	}
+
+	if *dir == "" {
+		return usageError{fmt.Sprintf("the directory of the fake slurm (-dir) must be given to run %s", command)}
+	}
+	f, err := OpenFakeSlurm(*dir)
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "sbatch":
+		return FakeSbatch(os.Stdout, f, fs.Args()[1:])
+	case "squeue":
+		return FakeSqueue(os.Stdout, f, fs.Args()[1:])
+	case "scancel":
+		return FakeScancel(f, fs.Args()[1:])
+	case "sacct":
+		return FakeSacct(os.Stdout, f, fs.Args()[1:])
+	case "run-queue":
+		// Started by sbatch, with the lock on runner.pid as file
+		// descriptor 3
+		lock := os.NewFile(3, "runner.pid")
+		if syscall.Flock(3, syscall.LOCK_EX|syscall.LOCK_NB) != nil {
+			return usageError{"run-queue is started by sbatch, with the lock on runner.pid"}
+		}
+		return f.RunQueue(lock)
+	}
+	return usageError{fmt.Sprintf("unknown command `%s` - expected install, or one of %s", command, strings.Join(fakeSlurmCommands, ", "))}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSlurmArgs(t *testing.T) {
+	cases := []struct {
+		args       []string
+		wantValues map[string]string
+		wantArgs   []string
+	}{
+		{[]string{"-J", "x", "job.sh"}, map[string]string{"job-name": "x"}, []string{"job.sh"}},
+		{[]string{"-Jx", "job.sh"}, map[string]string{"job-name": "x"}, []string{"job.sh"}},
+		{[]string{"--job-name=x", "job.sh"}, map[string]string{"job-name": "x"}, []string{"job.sh"}},
+		{[]string{"--job-name", "x", "job.sh"}, map[string]string{"job-name": "x"}, []string{"job.sh"}},
+		{[]string{"--time=1:00", "-o", "out_%j", "--parsable", "job.sh"},
+			map[string]string{"time": "1:00", "output": "out_%j", "parsable": "true"}, []string{"job.sh"}},
+		// What follows the script is passed on to it, options included
+		{[]string{"-J", "x", "job.sh", "-J", "y", "--time=5"},
+			map[string]string{"job-name": "x"}, []string{"job.sh", "-J", "y", "--time=5"}},
+		{[]string{"--", "job.sh", "-J"}, map[string]string{}, []string{"job.sh", "-J"}},
+	}
+	for _, c := range cases {
+		values, args, err := ParseSlurmArgs(c.args, sbatchOptions, true)
+		if err != nil {
+			t.Errorf("ParseSlurmArgs(%q) failed: %s", c.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(values, c.wantValues) || !reflect.DeepEqual(args, c.wantArgs) {
+			t.Errorf("ParseSlurmArgs(%q) = %v, %q, want %v, %q", c.args, values, args, c.wantValues, c.wantArgs)
+		}
+	}
+
+	for _, args := range [][]string{{"-Z", "job.sh"}, {"--job-name"}, {"-J"}} {
+		if _, _, err := ParseSlurmArgs(args, sbatchOptions, true); err == nil {
+			t.Errorf("ParseSlurmArgs(%q) did not fail", args)
+		}
+	}
+}
+
+// Returns a fake slurm in a temporary directory with `jobs` in its
+// queue. Queued jobs are run by the current process, so they are not
+// taken for lost.
+func newTestFakeSlurm(t *testing.T, jobs []FakeSlurmJob) FakeSlurm {
+	f := FakeSlurm{Dir: t.TempDir(), Config: DefaultFakeSlurmConfig()}
+	err := f.withQueue(func(q *JobQueue[*FakeSlurmJob]) error {
+		for i := range jobs {
+			jobs[i].Runner = os.Getpid()
+			q.Jobs = append(q.Jobs, &jobs[i])
+			q.NextID = jobs[i].ID
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// Returns a job of a fake slurm that was submitted `age` ago and is in
+// `state`, having finished a minute after it was submitted unless it is
+// still queued
+func testFakeSlurmJob(id int, name string, state string, age time.Duration) FakeSlurmJob {
+	job := FakeSlurmJob{QueuedJob: QueuedJob{ID: id, Name: name, State: state, Submitted: time.Now().Add(-age)}}
+	job.Started = job.Submitted
+	if !job.Queued() {
+		job.Finished = job.Submitted.Add(time.Minute)
+	}
+	return job
+}
+
+func TestFakeSqueueFormat(t *testing.T) {
+	options, _, err := ParseSlurmArgs(squeueListArgs, squeueOptions, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		job  FakeSlurmJob
+		want Job
+	}{
+		{testFakeSlurmJob(7, "sim_1___0", JobPending, 0), Job{"7", "sim_1___0", JobPending}},
+		{testFakeSlurmJob(1234567, "a_b___12", JobRunning, time.Hour), Job{"1234567", "a_b___12", JobRunning}},
+	}
+	for _, c := range cases {
+		line := FormatSqueueLine(options["format"], &c.job)
+		if got := ParseJobLines(line, " "); !reflect.DeepEqual(got, []Job{c.want}) {
+			t.Errorf("squeue line %q parsed as %v, want %v", line, got, c.want)
+		}
+	}
+
+	// The whole command, which leaves out finished jobs and the header
+	f := newTestFakeSlurm(t, []FakeSlurmJob{
+		testFakeSlurmJob(1, "sim___0", "COMPLETED", time.Hour),
+		cases[0].job,
+		cases[1].job,
+	})
+	var out bytes.Buffer
+	if err := FakeSqueue(&out, f, squeueListArgs); err != nil {
+		t.Fatal(err)
+	}
+	want := []Job{cases[0].want, cases[1].want}
+	if got := ParseJobLines(out.String(), " "); !reflect.DeepEqual(got, want) {
+		t.Errorf("squeue printed %q, parsed as %v, want %v", out.String(), got, want)
+	}
+}
+
+func TestFakeSacctHistory(t *testing.T) {
+	f := newTestFakeSlurm(t, []FakeSlurmJob{
+		testFakeSlurmJob(1, "sim___0", "COMPLETED", 48*time.Hour),
+		testFakeSlurmJob(2, "sim___1", "COMPLETED", time.Hour),
+		testFakeSlurmJob(3, "sim___2", "FAILED", time.Hour),
+		testFakeSlurmJob(4, "sim___3", "TIMEOUT", time.Hour),
+		testFakeSlurmJob(5, "sim___4", "CANCELLED", time.Hour),
+		testFakeSlurmJob(6, "sim___5", "NODE_FAIL", time.Hour),
+		testFakeSlurmJob(7, "sim___6", JobRunning, time.Hour),
+		testFakeSlurmJob(8, "sim___7", JobPending, 0),
+	})
+	var out bytes.Buffer
+	if err := FakeSacct(&out, f, SacctHistoryArgs(time.Now().Add(-2*time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	want := []Job{
+		{"2", "sim___1", "COMPLETED"},
+		{"3", "sim___2", "FAILED"},
+		{"4", "sim___3", "TIMEOUT"},
+		{"5", "sim___4", "CANCELLED"},
+		{"6", "sim___5", "NODE_FAIL"},
+	}
+	if got := ParseJobLines(out.String(), "|"); !reflect.DeepEqual(got, want) {
+		t.Errorf("sacct printed %q, parsed as %v, want %v", out.String(), got, want)
+	}
+	if strings.Contains(out.String(), " ") {
+		t.Errorf("sacct --parsable2 padded its fields: %q", out.String())
+	}
+}
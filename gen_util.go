@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 // Get all subdirectories of directory `target`
@@ -27,6 +31,38 @@ func GetSubdirs(target string) ([]string, error) {
 	return dirs, nil
 }
 
+// Returns the paths of all simulation directories at or below `simDir`,
+// i.e. directories that contain a `results` subdirectory. Meta
+// directories are searched recursively.
+func GetLeafDirs(simDir string) ([]string, error) {
+	resultsExists, err := DirExists(filepath.Join(simDir, "results"))
+	if err != nil {
+		return nil, err
+	}
+	if resultsExists {
+		return []string{simDir}, nil
+	}
+
+	allSubdirs, err := GetSubdirs(simDir)
+	if err != nil {
+		return nil, err
+	}
+
+	leafDirs := make([]string, 0, len(allSubdirs))
+	for _, subdir := range allSubdirs {
+		if subdir == ".glurmo" {
+			continue
+		}
+		subLeafDirs, err := GetLeafDirs(filepath.Join(simDir, subdir))
+		if err != nil {
+			return nil, err
+		}
+		leafDirs = append(leafDirs, subLeafDirs...)
+	}
+
+	return leafDirs, nil
+}
+
 // copy file `src` to `dest`
 func CopyFile(src, dest string) error {
 	file_contents, err := os.ReadFile(src)
@@ -71,41 +107,6 @@ func DirExists(target string) (bool, error) {
 	return targetInfo.IsDir(), nil
 }
 
-// Remove a file or directory if it exists. If the
-// file or directory did not exist, does nothing,
-// and returns nil
-func RemoveIfExists(target string) error {
-	targetInfo, err := os.Stat(target)
-	if err != nil {
-		if err.(*fs.PathError).Err.Error() == "no such file or directory" {
-			return nil
-		}
-		return err
-	}
-
-	err = nil
-	if targetInfo.IsDir() {
-		var err = os.RemoveAll(target)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// Removes everything in the `paths` slice. If the
-// target does not exist, does not return an error
-func RemoveAllSlice(paths []string) error {
-	for _, path := range paths {
-		err := os.RemoveAll(path)
-		if err != nil && err.(*fs.PathError).Err.Error() != "no such file or directory" {
-			return err
-		}
-	}
-	return nil
-}
-
 // Runs a command (`command`) with arguments `args`,
 // and returns resulting output as a string
 func CommandString(command string, args ...string) (string, error) {
@@ -119,3 +120,56 @@ func CommandString(command string, args ...string) (string, error) {
 
 	return rawOutput.String(), nil
 }
+
+// Checks whether a process with id `pid` is alive
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// Reads the JSON file `path` into `value`, calls `update`, and writes
+// `value` back to `path`, all while holding an exclusive lock on
+// `path`.lock, so that processes updating the same file take turns. If
+// `path` does not exist yet, `value` is left as it is. The file is
+// replaced in one step, so it is never left half written.
+func UpdateLockedJSON(path string, value any, update func() error) error {
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	contents, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		err = json.Unmarshal(contents, value)
+		if err != nil {
+			return errorString{fmt.Sprintf("%s is malformed: %s", path, err)}
+		}
+	}
+
+	err = update()
+	if err != nil {
+		return err
+	}
+
+	valueJSON, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path+".tmp", valueJSON, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -2,4 +2,7 @@ module github.com/nateosher/glurmo
 
 go 1.21.5
 
-require golang.org/x/tools v0.22.0 // indirect
+require (
+	github.com/BurntSushi/toml v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
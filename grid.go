@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Directory layouts for the leaves of an expanded parameter grid.
+// With the nested layout every grid axis adds a directory level
+// (`n_100/p_10`); with the flat layout each leaf is a single
+// directory below the simulation directory (`n_100__p_10`).
+const (
+	nestedLayout = "nested"
+	flatLayout   = "flat"
+)
+
+// A single level of a grid axis. `Label` is the directory name
+// component of the level, `ID` is appended to the simulation id, and
+// `Values` are the template variables it sets.
+type GridLevel struct {
+	Label  string
+	ID     string
+	Values map[string]any
+}
+
+// One axis of a parameter grid, i.e. one list or dict variable and
+// all of the values it takes
+type GridAxis struct {
+	Name   string
+	Levels []GridLevel
+}
+
+// One leaf of a parameter grid: a combination of one level from
+// every axis. `Labels` and `IDParts` are in axis order.
+type GridPoint struct {
+	Labels  []string
+	IDParts []string
+	Values  map[string]any
+}
+
+// Returns the grid axes defined by the list and dict variables of
+// `settingsMap`, ordered by variable name. Each zip group forms a
+// single axis, ordered by its first variable.
+func GetGridAxes(settingsMap SettingsMap) ([]GridAxis, error) {
+	listVariables := GetListVars(settingsMap.Templates)
+
+	zipGroups := make(map[string][]string, len(settingsMap.Zip))
+	zipped := make(map[string]bool)
+	for _, group := range settingsMap.Zip {
+		if len(group) < 2 {
+			return nil, errorString{fmt.Sprintf("zip group %v must contain at least two list variables", group)}
+		}
+		for _, name := range group {
+			if _, isList := listVariables[name]; !isList {
+				return nil, errorString{fmt.Sprintf("zip group %v contains '%s', which is not a list variable", group, name)}
+			}
+			if zipped[name] {
+				return nil, errorString{fmt.Sprintf("list variable '%s' appears in more than one zip group", name)}
+			}
+			zipped[name] = true
+		}
+		zipGroups[group[0]] = group
+	}
+
+	variableNames := append(KeySlice(listVariables), KeySlice(settingsMap.Dicts)...)
+	slices.Sort(variableNames)
+
+	axes := make([]GridAxis, 0, len(variableNames))
+	for _, name := range variableNames {
+		var axis GridAxis
+		var err error
+		if dict, isDict := settingsMap.Dicts[name]; isDict {
+			axis, err = GetDictAxis(name, dict, settingsMap)
+		} else if group, isGroup := zipGroups[name]; isGroup {
+			axis, err = GetZipAxis(group, listVariables)
+		} else if zipped[name] {
+			continue
+		} else {
+			axis, err = GetListAxis(name, listVariables[name])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, level := range axis.Levels {
+			if strings.ContainsRune(level.ID, filepath.Separator) {
+				return nil, errorString{fmt.Sprintf("value '%s' of variable '%s' cannot be used in a directory name", level.ID, axis.Name)}
+			}
+		}
+		axes = append(axes, axis)
+	}
+
+	return axes, nil
+}
+
+// Returns the grid axis of the list variable `name`, whose
+// value is the list `list`
+func GetListAxis(name string, list any) (GridAxis, error) {
+	variableValues, err := ListValues(list)
+	if err != nil {
+		return GridAxis{}, errorString{fmt.Sprintf("could not parse list variable '%s': %s", name, err)}
+	}
+	if len(variableValues) == 0 {
+		return GridAxis{}, errorString{fmt.Sprintf("script variable '%s' contains an empty list", name)}
+	}
+
+	axis := GridAxis{Name: name, Levels: make([]GridLevel, 0, len(variableValues))}
+	for _, value := range variableValues {
+		axis.Levels = append(axis.Levels, GridLevel{
+			Label:  fmt.Sprintf("%s_%s", name, FormatValue(value)),
+			ID:     FormatValue(value),
+			Values: map[string]any{name: value},
+		})
+	}
+
+	return axis, nil
+}
+
+// Returns the grid axis of the zip group `group`, whose list variables
+// are paired element-wise: the i-th level sets every variable of the
+// group to its i-th value. All lists must have the same length.
+func GetZipAxis(group []string, listVariables map[string]any) (GridAxis, error) {
+	groupAxes := make([]GridAxis, 0, len(group))
+	for _, name := range group {
+		axis, err := GetListAxis(name, listVariables[name])
+		if err != nil {
+			return GridAxis{}, err
+		}
+		if len(groupAxes) > 0 && len(axis.Levels) != len(groupAxes[0].Levels) {
+			return GridAxis{}, errorString{fmt.Sprintf("zipped list variables must have the same length, "+
+				"but '%s' has %d values and '%s' has %d", groupAxes[0].Name, len(groupAxes[0].Levels),
+				name, len(axis.Levels))}
+		}
+		groupAxes = append(groupAxes, axis)
+	}
+
+	zipAxis := GridAxis{Name: strings.Join(group, "+"), Levels: make([]GridLevel, 0, len(groupAxes[0].Levels))}
+	for i := range groupAxes[0].Levels {
+		labels := make([]string, 0, len(group))
+		ids := make([]string, 0, len(group))
+		values := make(map[string]any, len(group))
+		for _, axis := range groupAxes {
+			labels = append(labels, axis.Levels[i].Label)
+			ids = append(ids, axis.Levels[i].ID)
+			maps.Copy(values, axis.Levels[i].Values)
+		}
+		zipAxis.Levels = append(zipAxis.Levels, GridLevel{
+			Label:  strings.Join(labels, "_"),
+			ID:     strings.Join(ids, "_"),
+			Values: values,
+		})
+	}
+
+	return zipAxis, nil
+}
+
+// Returns the grid axis of the dict variable `name`. Each key of
+// `dict` is a level, which sets the variable to the key along with
+// the settings the key maps to. Those settings may not be list or
+// dict variables themselves.
+func GetDictAxis(name string, dict map[string]map[string]any, settingsMap SettingsMap) (GridAxis, error) {
+	if len(dict) == 0 {
+		return GridAxis{}, errorString{fmt.Sprintf("dict variable '%s' has no values", name)}
+	}
+
+	axis := GridAxis{Name: name, Levels: make([]GridLevel, 0, len(dict))}
+	for _, key := range SortedKeys(dict) {
+		values := map[string]any{name: key}
+		for setting, value := range dict[key] {
+			_, isDict := settingsMap.Dicts[setting]
+			if isDict || IsListVar(settingsMap.Templates[setting]) || IsListVar(value) {
+				return GridAxis{}, errorString{fmt.Sprintf("value '%s' of dict variable '%s' sets '%s', "+
+					"but settings of dict variables cannot be list or dict variables", key, name, setting)}
+			}
+			values[setting] = value
+		}
+
+		axis.Levels = append(axis.Levels, GridLevel{
+			Label:  fmt.Sprintf("%s_%s", name, key),
+			ID:     key,
+			Values: values,
+		})
+	}
+
+	return axis, nil
+}
+
+// Returns the Cartesian product of `axes`. The first axis varies
+// slowest, so leaves sharing a parent directory are contiguous.
+func ExpandGrid(axes []GridAxis) []GridPoint {
+	points := []GridPoint{{Values: make(map[string]any)}}
+	for _, axis := range axes {
+		expanded := make([]GridPoint, 0, len(points)*len(axis.Levels))
+		for _, point := range points {
+			for _, level := range axis.Levels {
+				newPoint := GridPoint{
+					Labels:  append(slices.Clone(point.Labels), level.Label),
+					IDParts: append(slices.Clone(point.IDParts), level.ID),
+					Values:  maps.Clone(point.Values),
+				}
+				maps.Copy(newPoint.Values, level.Values)
+				expanded = append(expanded, newPoint)
+			}
+		}
+		points = expanded
+	}
+
+	return points
+}
+
+// Returns the directory of the leaf `p`, relative to the simulation
+// directory, for the given layout
+func (p GridPoint) Dir(layout string) string {
+	if layout == flatLayout {
+		return strings.Join(p.Labels, "__")
+	}
+	return filepath.Join(p.Labels...)
+}
+
+// Checks that no two of `points` share a leaf directory in `layout`,
+// which happens when a list contains the same value twice, when two
+// values format the same (`1` and `"1"`), or when flat labels joined
+// by `__` coincide
+func CheckLeafDirs(points []GridPoint, layout string) error {
+	seen := make(map[string]int, len(points))
+	for i, point := range points {
+		dir := point.Dir(layout)
+		if j, isSeen := seen[dir]; isSeen {
+			return errorString{fmt.Sprintf("grid points %s and %s would both be set up in %s",
+				DescribeGridPoint(points[j]), DescribeGridPoint(point), dir)}
+		}
+		seen[dir] = i
+	}
+	return nil
+}
+
+// Returns the values of the leaf `p` as a JSON object, for messages
+func DescribeGridPoint(p GridPoint) string {
+	valuesJSON, err := json.Marshal(p.Values)
+	if err != nil {
+		return fmt.Sprint(p.Values)
+	}
+	return string(valuesJSON)
+}
+
+// Returns the layout requested by the "layout" general setting;
+// defaults to the nested layout
+func GetLayout(generalSettings map[string]any) (string, error) {
+	if _, hasKey := generalSettings["layout"]; !hasKey {
+		return nestedLayout, nil
+	}
+	layout := GetString(generalSettings, "layout")
+	if layout != nestedLayout && layout != flatLayout {
+		return "", errorString{fmt.Sprintf("\"layout\" must be either \"%s\" or \"%s\", got \"%s\"",
+			nestedLayout, flatLayout, layout)}
+	}
+	return layout, nil
+}
+
+// Returns the settings of the leaf `p` of a grid expanded from
+// `settingsMap`: list variables are replaced by the values of the
+// leaf, dict variables are replaced by the value of the leaf and the
+// settings it brings, and the leaf values are appended to the
+// simulation id.
+func (p GridPoint) Settings(settingsMap SettingsMap) SettingsMap {
+	newSettings := DeepCopySettings(settingsMap)
+	newSettings.Dicts = nil
+	newSettings.Zip = nil
+	newSettings.Exclude = nil
+	newSettings.Constraints = nil
+	for name, value := range p.Values {
+		newSettings.Templates[name] = DeepCopyValue(value)
+	}
+	if len(p.IDParts) > 0 {
+		newSettings.General["id"] = settingsMap.ID() + "_" + strings.Join(p.IDParts, "_")
+	}
+	return newSettings
+}
+
+// Stages writing the design manifest `design.json` to `simDir`, mapping the
+// directory of every leaf to the parameter values of that leaf
+func WriteDesign(t *Transaction, simDir string, points []GridPoint, layout string) error {
+	design := make(map[string]map[string]any, len(points))
+	for _, point := range points {
+		design[filepath.ToSlash(point.Dir(layout))] = point.Values
+	}
+
+	designJSON, err := json.MarshalIndent(design, "", "\t")
+	if err != nil {
+		return errorString{fmt.Sprintf("could not write design manifest: %s", err)}
+	}
+	err = t.WriteFile(filepath.Join(simDir, "design.json"), designJSON)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not write design manifest: %s", err)}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckLeafDirs(t *testing.T) {
+	cases := []struct {
+		name     string
+		settings string
+		layout   string
+		wantErr  string
+	}{
+		{"distinct", `{"templates": {"n": [1, 2], "p": ["a", "b"]}}`, nestedLayout, ""},
+		{"distinct flat", `{"templates": {"n": [1, 2], "p": ["a", "b"]}}`, flatLayout, ""},
+		{"duplicate value", `{"templates": {"n": [1, 2, 1]}}`, nestedLayout,
+			`grid points {"n":1} and {"n":1} would both be set up in n_1`},
+		{"equal formatting", `{"templates": {"n": [1, "1"]}}`, nestedLayout,
+			`grid points {"n":1} and {"n":"1"} would both be set up in n_1`},
+		{"flat labels", `{"templates": {"a": ["x__b_y", "x"], "b": ["z", "y__b_z"]}}`, flatLayout,
+			`grid points {"a":"x__b_y","b":"z"} and {"a":"x","b":"y__b_z"} would both be set up in a_x__b_y__b_z`},
+		{"flat labels nested", `{"templates": {"a": ["x__b_y", "x"], "b": ["z", "y__b_z"]}}`, nestedLayout, ""},
+	}
+
+	for _, c := range cases {
+		settingsMap, err := ParseSettings([]byte(c.settings), "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		axes, err := GetGridAxes(settingsMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = CheckLeafDirs(ExpandGrid(axes), c.layout)
+		if c.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err)
+		}
+		if c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+			t.Errorf("%s: got error %v, want one containing %s", c.name, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Sets up the leaves of `points` without removing previous setups:
+// new leaves are created, and existing leaves only have the files whose
+// inputs changed rewritten, unless `t.NoClobber` is set, in which case
+// they are left alone. Results and logs are left alone unless
+// `options.Clean` is set, in which case leaves that are no longer part
+// of the grid are removed along with the outputs of simulations that
+// changed.
+func SetupIncremental(w io.Writer, t *Transaction, simDir string, settingsMap SettingsMap, points []GridPoint, layout string, options SetupOptions) error {
+	expected := make([]string, 0, len(points))
+	nNew, nUpdated, nUnchanged, nChangedSims := 0, 0, 0, 0
+	for _, point := range points {
+		leafDir := filepath.Join(simDir, point.Dir(layout))
+		expected = append(expected, leafDir)
+
+		exists, err := DirExists(leafDir)
+		if err != nil {
+			return errorString{fmt.Sprintf("could not check %s: %s", leafDir, err)}
+		}
+		if !exists {
+			fmt.Fprintln(w, "Creating ", leafDir, "...")
+		} else if t.NoClobber {
+			nUnchanged++
+			continue
+		}
+
+		changed, err := SetupLeaf(t, simDir, leafDir, point.Settings(settingsMap), point.Labels)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case !exists:
+			nNew++
+		case len(changed) > 0:
+			fmt.Fprintf(w, "Updating %s (%d simulations changed) ...\n", leafDir, len(changed))
+			nUpdated++
+			nChangedSims += len(changed)
+			if options.Clean {
+				err = RemoveSimOutputs(t, leafDir, changed)
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			nUnchanged++
+		}
+	}
+
+	staleDirs, err := StaleLeafDirs(simDir, expected)
+	if err != nil {
+		return err
+	}
+	for _, leafDir := range staleDirs {
+		if !options.Clean {
+			fmt.Fprintf(w, "WARNING: %s is no longer part of the grid - it was left in place (use -clean to remove it)\n", leafDir)
+			continue
+		}
+		fmt.Fprintln(w, "Removing ", leafDir, "...")
+		err = RemoveLeaf(t, simDir, leafDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "%d new, %d updated (%d simulations changed), %d unchanged leaves\n",
+		nNew, nUpdated, nChangedSims, nUnchanged)
+	return nil
+}
+
+// Reports the simulations of the single simulation directory `simDir`
+// that an incremental setup changed, removing their outputs if
+// `options.Clean` is set
+func FinishIncrementalSetup(w io.Writer, t *Transaction, simDir string, changed []int, options SetupOptions) error {
+	fmt.Fprintf(w, "%d simulations changed in %s\n", len(changed), simDir)
+	if options.Clean {
+		return RemoveSimOutputs(t, simDir, changed)
+	}
+	return nil
+}
+
+// Returns the leaves of `simDir` that are not among `expected`, i.e.
+// that are no longer part of its grid
+func StaleLeafDirs(simDir string, expected []string) ([]string, error) {
+	leafDirs, err := GetLeafDirs(simDir)
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("could not look for leaves that are no longer needed: %s", err)}
+	}
+	stale := make([]string, 0)
+	for _, leafDir := range leafDirs {
+		if leafDir != simDir && !slices.Contains(expected, leafDir) {
+			stale = append(stale, leafDir)
+		}
+	}
+	return stale, nil
+}
+
+// Stages writing `contents` to `path` in `t`, unless the file already
+// has exactly those contents. Returns whether the file is written.
+func WriteIfChanged(t *Transaction, path string, contents string) (bool, error) {
+	existing, err := t.ReadFile(path)
+	if err == nil && bytes.Equal(existing, []byte(contents)) {
+		return false, nil
+	}
+
+	err = t.WriteFile(path, []byte(contents))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Stages removing every file in `dir` that is not one of `keep`
+func RemoveOtherFiles(t *Transaction, dir string, keep []string) error {
+	if t.IsRemoved(dir) {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || slices.Contains(keep, path) {
+			continue
+		}
+		err = t.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stages removing the results, slurm output and slurm errors of the
+// simulations of `simDir` with the given indices
+func RemoveSimOutputs(t *Transaction, simDir string, indices []int) error {
+	outputPrefixes := map[string]string{
+		"results":      "results___",
+		"slurm_out":    "output___",
+		"slurm_errors": "error___",
+	}
+	for _, subdir := range SortedKeys(outputPrefixes) {
+		entries, err := os.ReadDir(filepath.Join(simDir, subdir))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, isOutput := strings.CutPrefix(entry.Name(), outputPrefixes[subdir])
+			if !isOutput {
+				continue
+			}
+			index, err := strconv.Atoi(strings.SplitN(name, ".", 2)[0])
+			if err != nil || !slices.Contains(indices, index) {
+				continue
+			}
+			err = t.Remove(filepath.Join(simDir, subdir, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stages removing `leafDir`, then any directories between it and
+// `simDir` that would be left empty
+func RemoveLeaf(t *Transaction, simDir string, leafDir string) error {
+	err := t.Remove(leafDir)
+	if err != nil {
+		return err
+	}
+	for dir := filepath.Dir(leafDir); dir != filepath.Clean(simDir) && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !t.IsRemoved(filepath.Join(dir, entry.Name())) {
+				return nil
+			}
+		}
+		err = t.Remove(dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
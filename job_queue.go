@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// What every job of a job queue kept on disk records, shared by the
+// local scheduler and the fake slurm
+type QueuedJob struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+	// The glurmo process that runs the job
+	Runner int `json:"runner"`
+	// The process group of the job, once it is running
+	PID       int       `json:"pid,omitempty"`
+	ExitCode  int       `json:"exit_code"`
+	Submitted time.Time `json:"submitted"`
+	Finished  time.Time `json:"finished"`
+}
+
+// Checks whether `job` is pending or running
+func (job QueuedJob) Queued() bool {
+	return job.State == JobPending || job.State == JobRunning
+}
+
+// Returns `job` itself, which lets job queues reach the fields of the
+// jobs that embed it
+func (job *QueuedJob) queuedJob() *QueuedJob {
+	return job
+}
+
+// A job type that embeds `QueuedJob`
+type queueJob interface {
+	queuedJob() *QueuedJob
+}
+
+// A job queue as it is saved on disk
+type JobQueue[J queueJob] struct {
+	NextID int `json:"next_id"`
+	Jobs   []J `json:"jobs"`
+}
+
+// Returns the job of `q` with id `id`, or nil if there is none
+func (q *JobQueue[J]) find(id int) J {
+	for _, job := range q.Jobs {
+		if job.queuedJob().ID == id {
+			return job
+		}
+	}
+	var none J
+	return none
+}
+
+// Returns the number of jobs of `q` that are running
+func (q *JobQueue[J]) running() int {
+	n := 0
+	for _, job := range q.Jobs {
+		if job.queuedJob().State == JobRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// Drops the jobs of `q` that finished more than `retention` ago
+func (q *JobQueue[J]) prune(retention time.Duration) {
+	now := time.Now()
+	q.Jobs = slices.DeleteFunc(q.Jobs, func(queued J) bool {
+		job := queued.queuedJob()
+		return !job.Queued() && now.Sub(job.Finished) >= retention
+	})
+}
+
+// Calls `update` with the job queue kept in `path`, described as
+// `description` in errors, while holding a lock on it, then saves the
+// queue. Before `update` is called, queued jobs whose runner has exited,
+// e.g. because the machine was rebooted, are marked as having ended in
+// `lostState`, unless they are still running.
+func UpdateJobQueue[J queueJob](path string, description string, lostState string, update func(q *JobQueue[J]) error) error {
+	q := JobQueue[J]{Jobs: make([]J, 0)}
+	var updateErr error
+	err := UpdateLockedJSON(path, &q, func() error {
+		for _, queued := range q.Jobs {
+			job := queued.queuedJob()
+			if job.Queued() && !ProcessAlive(job.Runner) && !(job.State == JobRunning && ProcessAlive(job.PID)) {
+				job.State = lostState
+				job.Finished = time.Now()
+			}
+		}
+		updateErr = update(&q)
+		return updateErr
+	})
+	if err != nil && err != updateErr {
+		return errorString{fmt.Sprintf("could not update %s: %s", description, err)}
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpdateJobQueueMarksLostJobs(t *testing.T) {
+	// A process that has exited, so its pid is not alive
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	deadPID := exited.Process.Pid
+
+	path := filepath.Join(t.TempDir(), "queue.json")
+	err := UpdateJobQueue(path, "test queue", "LOST", func(q *JobQueue[*LocalJob]) error {
+		for _, state := range []string{JobPending, JobRunning} {
+			q.NextID++
+			q.Jobs = append(q.Jobs, &LocalJob{QueuedJob: QueuedJob{ID: q.NextID, State: state, Runner: deadPID}})
+		}
+		q.NextID++
+		q.Jobs = append(q.Jobs, &LocalJob{QueuedJob: QueuedJob{ID: q.NextID, State: JobRunning, Runner: os.Getpid()}})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = UpdateJobQueue(path, "test queue", "LOST", func(q *JobQueue[*LocalJob]) error {
+		for id, want := range map[int]string{1: "LOST", 2: "LOST", 3: JobRunning} {
+			job := q.find(id)
+			if job == nil || job.State != want {
+				t.Errorf("job %d is %+v, want state %s", id, job, want)
+			}
+		}
+		if q.find(4) != nil {
+			t.Errorf("found job 4, which was never queued")
+		}
+		if q.running() != 1 {
+			t.Errorf("%d jobs running, want 1", q.running())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobQueuePrune(t *testing.T) {
+	now := time.Now()
+	q := JobQueue[*FakeSlurmJob]{Jobs: []*FakeSlurmJob{
+		{QueuedJob: QueuedJob{ID: 1, State: "COMPLETED", Finished: now.Add(-2 * time.Hour)}},
+		{QueuedJob: QueuedJob{ID: 2, State: "FAILED", Finished: now.Add(-time.Minute)}},
+		{QueuedJob: QueuedJob{ID: 3, State: JobPending, Submitted: now.Add(-3 * time.Hour)}},
+	}}
+	q.prune(time.Hour)
+	if len(q.Jobs) != 2 || q.find(1) != nil {
+		t.Errorf("kept %d jobs including job 1: %v", len(q.Jobs), q.find(1))
+	}
+}
+
+func TestFakeSlurmRunnerHasWork(t *testing.T) {
+	now := time.Now()
+	job := func(state string, submitted time.Time) *FakeSlurmJob {
+		return &FakeSlurmJob{QueuedJob: QueuedJob{State: state, Submitted: submitted}}
+	}
+	cases := []struct {
+		name string
+		jobs []*FakeSlurmJob
+		want bool
+	}{
+		{"empty queue", []*FakeSlurmJob{}, true},
+		{"only finished jobs", []*FakeSlurmJob{job("COMPLETED", now)}, true},
+		{"pending too briefly", []*FakeSlurmJob{job(JobPending, now)}, false},
+		{"ready", []*FakeSlurmJob{job(JobPending, now.Add(-time.Minute))}, true},
+		{"no free slot", []*FakeSlurmJob{job(JobRunning, now), job(JobPending, now.Add(-time.Minute))}, false},
+	}
+	for _, c := range cases {
+		q := JobQueue[*FakeSlurmJob]{Jobs: c.jobs}
+		if got := FakeSlurmRunnerHasWork(q, time.Second, 1); got != c.want {
+			t.Errorf("%s: FakeSlurmRunnerHasWork = %t, want %t", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Keys that glurmo adds to the data of the script template, and
+// (together with the script keys) to the data of the slurm template
+var (
+	scriptTemplateKeys = []string{"index", "results_path", "path_to_script", "sim_id"}
+	slurmTemplateKeys  = []string{"path_to_slurm_script", "job_id", "output_path", "error_path", "id"}
+)
+
+// Template settings that glurmo itself reads, so they need not be
+// used by a template
+var glurmoTemplateSettings = []string{"script_extension", "result_extension"}
+
+// A problem found in the settings or templates of a simulation
+// directory. `File` is relative to the simulation directory, and
+// `Line` is 0 if the problem cannot be tied to a line. Warnings
+// do not stop glurmo from running.
+type LintIssue struct {
+	File    string
+	Line    int
+	Message string
+	Warning bool
+}
+
+func (issue LintIssue) String() string {
+	location := issue.File
+	if issue.Line > 0 {
+		location = fmt.Sprintf("%s:%d", issue.File, issue.Line)
+	}
+	severity := "error"
+	if issue.Warning {
+		severity = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", location, severity, issue.Message)
+}
+
+// The settings file of a simulation directory, along with what is
+// needed to point lint issues at lines of it
+type lintSettings struct {
+	file     string
+	format   string
+	contents string
+	settings SettingsMap
+	parsed   bool
+}
+
+// Adds an issue about `key` of the settings file to `issues`
+func (s lintSettings) issue(issues []LintIssue, key string, warning bool, format string, args ...any) []LintIssue {
+	return append(issues, LintIssue{
+		File:    s.file,
+		Line:    FindKeyLine(s.contents, key, s.format),
+		Message: fmt.Sprintf(format, args...),
+		Warning: warning,
+	})
+}
+
+// Checks the settings and both templates of the simulation directory
+// `simDir`, and returns every problem found
+func LintSimDir(simDir string) ([]LintIssue, error) {
+	settings, issues, err := LintSettings(simDir)
+	if err != nil || !settings.parsed {
+		return issues, err
+	}
+
+	return append(issues, LintTemplates(simDir, settings)...), nil
+}
+
+// Checks the settings file of the simulation directory `simDir`.
+// Returns the settings along with every problem found; the error is
+// only set if the settings file could not be found or read.
+func LintSettings(simDir string) (lintSettings, []LintIssue, error) {
+	var settings lintSettings
+	settingsDir, err := GetSettingsDir(simDir)
+	if err != nil {
+		return settings, nil, err
+	}
+	settingsPath, err := GetSettingsFile(settingsDir)
+	if err != nil {
+		return settings, nil, err
+	}
+	contents, err := os.ReadFile(settingsPath)
+	if err != nil {
+		return settings, nil, err
+	}
+
+	settings.file = filepath.Join(".glurmo", filepath.Base(settingsPath))
+	settings.format = strings.TrimPrefix(filepath.Ext(settingsPath), ".")
+	settings.contents = string(contents)
+
+	issues := make([]LintIssue, 0)
+	settings.settings, err = GetSettingsMap(settingsPath)
+	if err != nil {
+		return settings, append(issues, LintIssue{
+			File:    settings.file,
+			Line:    SyntaxErrorLine(contents, settings.format),
+			Message: err.Error(),
+		}), nil
+	}
+	settings.parsed = true
+	settingsMap := settings.settings
+
+	// Required general settings
+	if _, hasID := settingsMap.General["id"]; !hasID {
+		issues = settings.issue(issues, "general", false, "\"id\" must be specified in the \"general\" section")
+	} else if !IsScalar(settingsMap.General["id"]) || settingsMap.ID() == "" {
+		issues = settings.issue(issues, "id", false, "\"id\" must be a non-empty string")
+	}
+	if _, hasNSims := settingsMap.General["n_sims"]; !hasNSims {
+		issues = settings.issue(issues, "general", false, "\"n_sims\" must be specified in the \"general\" section")
+	} else if nSims, err := AsInt(settingsMap.General["n_sims"]); err != nil {
+		issues = settings.issue(issues, "n_sims", false, "\"n_sims\" must be an integer, got %s", GetString(settingsMap.General, "n_sims"))
+	} else if nSims < 1 {
+		issues = settings.issue(issues, "n_sims", false, "\"n_sims\" must be positive, got %d", nSims)
+	}
+	if _, err := GetLayout(settingsMap.General); err != nil {
+		issues = settings.issue(issues, "layout", false, "%s", err)
+	}
+	if _, err := NewScheduler(settingsMap.General); err != nil {
+		issues = settings.issue(issues, "scheduler", false, "%s", err)
+	}
+
+	// Template settings
+	if _, hasExtension := settingsMap.Templates["script_extension"]; !hasExtension {
+		issues = settings.issue(issues, "templates", true, "\"script_extension\" is not set, so scripts will have no file extension")
+	}
+	for _, name := range SortedKeys(GetListVars(settingsMap.Templates)) {
+		values, err := ListValues(settingsMap.Templates[name])
+		if err != nil {
+			issues = settings.issue(issues, name, false, "malformed list variable '%s': %s", name, err)
+		} else if len(values) == 0 {
+			issues = settings.issue(issues, name, false, "list variable '%s' is empty", name)
+		}
+	}
+
+	for _, constraint := range settingsMap.Constraints {
+		if _, err := ParseExpr(constraint); err != nil {
+			issues = settings.issue(issues, "constraints", false, "could not parse constraint `%s`: %s", constraint, err)
+		}
+	}
+
+	// Only check the grid as a whole if every list is well formed,
+	// to avoid reporting the same problem twice
+	if !HasLintErrors(issues) {
+		axes, err := GetGridAxes(settingsMap)
+		if err != nil {
+			issues = settings.issue(issues, "templates", false, "%s", err)
+		} else if pruneRules, err := GetPruneRules(settingsMap, axes); err != nil {
+			issues = settings.issue(issues, "constraints", false, "%s", err)
+		} else if points, err := PruneGrid(ExpandGrid(axes), settingsMap.Templates, pruneRules); err != nil {
+			issues = settings.issue(issues, "constraints", false, "%s", err)
+		} else {
+			// A bad layout was reported above
+			layout, _ := GetLayout(settingsMap.General)
+			if err := CheckLeafDirs(points, layout); err != nil {
+				issues = settings.issue(issues, "templates", false, "%s", err)
+			}
+		}
+	}
+
+	return settings, issues, nil
+}
+
+// Checks that both templates of `simDir` parse, that every setting
+// they use is defined, and that every setting is used by a template
+func LintTemplates(simDir string, settings lintSettings) []LintIssue {
+	issues := make([]LintIssue, 0)
+	settingsMap := settings.settings
+
+	// Everything that is available to the templates of every leaf
+	defined := make(map[string]bool)
+	for name := range settingsMap.Templates {
+		defined[name] = true
+	}
+	for name, dict := range settingsMap.Dicts {
+		defined[name] = true
+		for _, overrides := range dict {
+			for setting := range overrides {
+				defined[setting] = true
+			}
+		}
+	}
+
+	used := make(map[string]bool)
+	allParsed := true
+	templateKeys := map[string][]string{
+		"script_template": scriptTemplateKeys,
+		"slurm_template":  append(slices.Clone(scriptTemplateKeys), slurmTemplateKeys...),
+	}
+	templateKeys["condor_template"] = templateKeys["slurm_template"]
+	// Partials and overrides may be used by any template
+	templateFiles := []string{"script_template", "slurm_template"}
+	if hasCondorTemplate, _ := FileExists(filepath.Join(simDir, ".glurmo", "condor_template")); hasCondorTemplate {
+		templateFiles = append(templateFiles, "condor_template")
+	}
+	partialFiles, err := GetPartialFiles(simDir)
+	if err != nil {
+		issues = append(issues, LintIssue{File: filepath.Join(".glurmo", "partials"), Message: fmt.Sprintf("could not read partials: %s", err)})
+		allParsed = false
+	}
+	overrideFiles, err := GetOverrideFiles(simDir, nil)
+	if err != nil {
+		issues = append(issues, LintIssue{File: filepath.Join(".glurmo", "overrides"), Message: fmt.Sprintf("could not read overrides: %s", err)})
+		allParsed = false
+	}
+	for _, templateName := range append(append(templateFiles, partialFiles...), overrideFiles...) {
+		keys, isTemplate := templateKeys[templateName]
+		if !isTemplate {
+			keys = templateKeys["slurm_template"]
+		}
+
+		file := filepath.Join(".glurmo", templateName)
+		contents, err := os.ReadFile(filepath.Join(simDir, file))
+		if err != nil {
+			issues = append(issues, LintIssue{File: file, Message: fmt.Sprintf("could not read template: %s", err)})
+			allParsed = false
+			continue
+		}
+
+		refs, err := TemplateReferences(templateName, string(contents))
+		if err != nil {
+			issues = append(issues, LintIssue{File: file, Line: TemplateErrorLine(err), Message: err.Error()})
+			allParsed = false
+			continue
+		}
+
+		for _, ref := range refs {
+			used[ref.Name] = true
+			if !defined[ref.Name] && !slices.Contains(keys, ref.Name) {
+				issues = append(issues, LintIssue{
+					File:    file,
+					Line:    ref.Line,
+					Message: fmt.Sprintf("template uses '%s', which is not defined in the settings", ref.Name),
+				})
+			}
+		}
+	}
+
+	if allParsed && !used["results_path"] {
+		issues = append(issues, LintIssue{
+			File:    filepath.Join(".glurmo", "script_template"),
+			Message: "template does not use '{{.results_path}}', so glurmo cannot tell when simulations complete",
+			Warning: true,
+		})
+	}
+
+	issues = append(issues, LintOverrideLevels(simDir, settingsMap)...)
+
+	// Settings used only by a template that does not parse would
+	// wrongly be reported as unused
+	if !allParsed {
+		return issues
+	}
+	for _, name := range SortedKeys(defined) {
+		if !used[name] && !slices.Contains(glurmoTemplateSettings, name) {
+			issues = settings.issue(issues, name, true, "setting '%s' is not used by either template", name)
+		}
+	}
+
+	return issues
+}
+
+// A reference to a top-level setting in a template, e.g. `.n` or
+// `$.n`, and the line it is on
+type TemplateReference struct {
+	Name string
+	Line int
+}
+
+// Parses the template `contents` and returns every reference to a
+// top-level setting. References inside `range` and `with` blocks
+// are relative to a different value, and are only collected if they
+// start from the root (`$`).
+func TemplateReferences(name string, contents string) ([]TemplateReference, error) {
+	tmpl, err := template.New(name).Funcs(TemplateFuncs()).Parse(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]TemplateReference, 0)
+	var walk func(node parse.Node, atRoot bool)
+	walk = func(node parse.Node, atRoot bool) {
+		if node == nil {
+			return
+		}
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, atRoot)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, atRoot)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd, atRoot)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg, atRoot)
+			}
+		case *parse.FieldNode:
+			if atRoot {
+				refs = append(refs, TemplateReference{n.Ident[0], LineOf(contents, int(n.Position()))})
+			}
+		case *parse.ChainNode:
+			walk(n.Node, atRoot)
+		case *parse.VariableNode:
+			if n.Ident[0] == "$" && len(n.Ident) > 1 {
+				refs = append(refs, TemplateReference{n.Ident[1], LineOf(contents, int(n.Position()))})
+			}
+		case *parse.IfNode:
+			walk(n.Pipe, atRoot)
+			walk(n.List, atRoot)
+			walk(n.ElseList, atRoot)
+		case *parse.RangeNode:
+			walk(n.Pipe, atRoot)
+			walk(n.List, false)
+			walk(n.ElseList, atRoot)
+		case *parse.WithNode:
+			walk(n.Pipe, atRoot)
+			walk(n.List, false)
+			walk(n.ElseList, atRoot)
+		case *parse.TemplateNode:
+			walk(n.Pipe, atRoot)
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root, true)
+		}
+	}
+
+	return refs, nil
+}
+
+// Returns the line number of byte offset `pos` in `contents`
+func LineOf(contents string, pos int) int {
+	return strings.Count(contents[:min(pos, len(contents))], "\n") + 1
+}
+
+// Returns the line a template parse error refers to, or 0 if it
+// does not refer to one. Parse errors look like
+// `template: name:12: unexpected ...`.
+func TemplateErrorLine(err error) int {
+	match := regexp.MustCompile(`^template: [^:]*:(\d+)`).FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+	var line int
+	fmt.Sscan(match[1], &line)
+	return line
+}
+
+// Returns the line of a syntax error in the settings file `contents`,
+// or 0 if it cannot be determined. YAML and TOML errors already
+// mention their line.
+func SyntaxErrorLine(contents []byte, format string) int {
+	if format != "json" {
+		return 0
+	}
+	var v any
+	err := json.Unmarshal(contents, &v)
+	if syntaxErr, isSyntaxErr := err.(*json.SyntaxError); isSyntaxErr {
+		return LineOf(string(contents), int(syntaxErr.Offset))
+	}
+	return 0
+}
+
+// Returns the first line of the settings file `contents` on which
+// the key `key` is set, or 0 if there is none
+func FindKeyLine(contents string, key string, format string) int {
+	quoted := regexp.QuoteMeta(key)
+	var pattern string
+	switch format {
+	case "json":
+		pattern = `"` + quoted + `"\s*:`
+	case "toml":
+		pattern = `(?m)(^\s*|[{,]\s*|\[\s*|\.)"?` + quoted + `"?\s*(=|\]|\.)`
+	default:
+		pattern = `(?m)(^\s*-?\s*|[{,]\s*)["']?` + quoted + `["']?\s*:`
+	}
+
+	loc := regexp.MustCompile(pattern).FindStringIndex(contents)
+	if loc == nil {
+		return 0
+	}
+	return LineOf(contents, loc[0])
+}
+
+// Checks whether any of `issues` is an error rather than a warning
+func HasLintErrors(issues []LintIssue) bool {
+	for _, issue := range issues {
+		if !issue.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// Prints `issues` to `w`, one per line, prefixed by `simDir`
+func PrintLintIssues(w io.Writer, simDir string, issues []LintIssue) {
+	for _, issue := range issues {
+		issue.File = filepath.Join(simDir, issue.File)
+		fmt.Fprintln(w, issue)
+	}
+}
+
+// Checks the simulation directory `simDir` before setting it up.
+// Warnings are printed; if there are any errors, they are all
+// printed and an error is returned.
+func ValidateSimDir(simDir string) error {
+	issues, err := LintSimDir(simDir)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not validate %s: %s", simDir, err)}
+	}
+
+	PrintLintIssues(os.Stderr, simDir, issues)
+	if HasLintErrors(issues) {
+		return errorString{fmt.Sprintf("found problems in the settings of %s - see above", simDir)}
+	}
+	return nil
+}
+
+// Checks the settings of every simulation directory at or below
+// `simDir` before submitting or cancelling jobs. Only errors are
+// printed; if there are any, an error is returned.
+func ValidateLeafSettings(simDir string) error {
+	leafDirs, err := GetLeafDirs(simDir)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not validate %s: %s", simDir, err)}
+	}
+
+	hasErrors := false
+	for _, leafDir := range leafDirs {
+		_, issues, err := LintSettings(leafDir)
+		if err != nil {
+			return errorString{fmt.Sprintf("could not validate %s: %s", leafDir, err)}
+		}
+		for _, issue := range issues {
+			if !issue.Warning {
+				hasErrors = true
+				issue.File = filepath.Join(leafDir, issue.File)
+				fmt.Fprintln(os.Stderr, issue)
+			}
+		}
+	}
+
+	if hasErrors {
+		return errorString{fmt.Sprintf("found problems in the settings below %s - see above", simDir)}
+	}
+	return nil
+}
+
+// Warns about directories of .glurmo/overrides that are not named
+// after a level of the grid of `simDir`, and so would never be used
+func LintOverrideLevels(simDir string, settingsMap SettingsMap) []LintIssue {
+	issues := make([]LintIssue, 0)
+	overrideLevels, err := GetOverrideLevels(simDir)
+	if err != nil || len(overrideLevels) == 0 {
+		return issues
+	}
+	axes, err := GetGridAxes(settingsMap)
+	if err != nil || len(axes) == 0 {
+		return issues
+	}
+
+	labels := make([]string, 0)
+	for _, axis := range axes {
+		for _, level := range axis.Levels {
+			labels = append(labels, level.Label)
+		}
+	}
+	for _, level := range overrideLevels {
+		if !slices.Contains(labels, level) {
+			issues = append(issues, LintIssue{
+				File:    filepath.Join(".glurmo", "overrides", level),
+				Message: fmt.Sprintf("overrides do not match any list or dict value - expected a name such as %s", labels[0]),
+				Warning: true,
+			})
+		}
+	}
+	return issues
+}
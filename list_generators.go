@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The largest number of values a list generator may produce, to
+// catch typos like `@range(1, 1000000000)` before they fill the disk
+const maxGeneratedValues = 100000
+
+// Matches list generators of the form `@name(arg_1, ..., arg_n)`
+var generatorRegexp = regexp.MustCompile(`^@([a-z]+)\((.*)\)$`)
+
+// Given a list generator such as `@range(1, 10, 2)`, returns the
+// values it generates. The supported generators are
+//
+//	@range(start, end[, step])        integers from start to end (inclusive)
+//	@seq(start, end[, step[, width]]) like range, zero padded to width digits
+//	@linspace(start, end, n)          n evenly spaced numbers from start to end
+//	@logspace(start, end, n)          n numbers from 10^start to 10^end, evenly
+//	                                  spaced on the log scale
+func UnpackGenerator(s string) ([]string, error) {
+	match := generatorRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return nil, errorString{fmt.Sprintf("malformed list generator `%s` - generators look like @name(arg, ...)", s)}
+	}
+
+	name := match[1]
+	args := strings.Split(match[2], ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+
+	var values []string
+	var err error
+	switch name {
+	case "range":
+		values, err = GenerateRange(args, false)
+	case "seq":
+		values, err = GenerateRange(args, true)
+	case "linspace":
+		values, err = GenerateLinspace(args, false)
+	case "logspace":
+		values, err = GenerateLinspace(args, true)
+	default:
+		return nil, errorString{fmt.Sprintf("unknown list generator `%s` - expected range, seq, linspace or logspace", name)}
+	}
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("invalid list generator `%s`: %s", s, err)}
+	}
+
+	return values, nil
+}
+
+// Generates the integers described by the arguments of @range or, if
+// `pad` is true, @seq
+func GenerateRange(args []string, pad bool) ([]string, error) {
+	maxArgs := 3
+	if pad {
+		maxArgs = 4
+	}
+	if len(args) < 2 || len(args) > maxArgs {
+		return nil, errorString{fmt.Sprintf("expected between 2 and %d arguments, got %d", maxArgs, len(args))}
+	}
+
+	ints := make([]int, len(args))
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, errorString{fmt.Sprintf("argument `%s` is not an integer", arg)}
+		}
+		ints[i] = n
+	}
+
+	start, end := ints[0], ints[1]
+	step := 1
+	if end < start {
+		step = -1
+	}
+	if len(ints) > 2 {
+		step = ints[2]
+	}
+	if step == 0 || (end-start)*step < 0 {
+		return nil, errorString{fmt.Sprintf("step %d does not lead from %d to %d", step, start, end)}
+	}
+
+	width := 0
+	if pad {
+		width = max(len(strconv.Itoa(start)), len(strconv.Itoa(end)))
+		if len(ints) > 3 {
+			width = ints[3]
+		}
+	}
+
+	nValues := (end-start)/step + 1
+	if nValues > maxGeneratedValues {
+		return nil, errorString{fmt.Sprintf("generates %d values, more than the maximum of %d", nValues, maxGeneratedValues)}
+	}
+
+	values := make([]string, 0, nValues)
+	for i := 0; i < nValues; i++ {
+		values = append(values, fmt.Sprintf("%0*d", width, start+i*step))
+	}
+
+	return values, nil
+}
+
+// Generates the numbers described by the arguments of @linspace or, if
+// `log` is true, @logspace
+func GenerateLinspace(args []string, log bool) ([]string, error) {
+	if len(args) != 3 {
+		return nil, errorString{fmt.Sprintf("expected 3 arguments, got %d", len(args))}
+	}
+
+	start, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("argument `%s` is not a number", args[0])}
+	}
+	end, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("argument `%s` is not a number", args[1])}
+	}
+	nValues, err := strconv.Atoi(args[2])
+	if err != nil || nValues < 1 {
+		return nil, errorString{fmt.Sprintf("number of values `%s` is not a positive integer", args[2])}
+	}
+	if nValues > maxGeneratedValues {
+		return nil, errorString{fmt.Sprintf("generates %d values, more than the maximum of %d", nValues, maxGeneratedValues)}
+	}
+
+	values := make([]string, 0, nValues)
+	for i := 0; i < nValues; i++ {
+		value := start
+		if nValues > 1 {
+			value = start + float64(i)*(end-start)/float64(nValues-1)
+		}
+		if log {
+			value = math.Pow(10, value)
+		}
+		values = append(values, FormatFloat(value))
+	}
+
+	return values, nil
+}
+
+// Formats a generated floating point value, rounding away the noise
+// of floating point arithmetic (0.30000000000000004 becomes 0.3)
+func FormatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', 12, 64)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// States of finished local jobs
+const (
+	localCompleted = "COMPLETED"
+	localFailed    = "FAILED"
+	localCancelled = "CANCELLED"
+	// The glurmo process running the job exited before it finished
+	localLost = "LOST"
+)
+
+// How long finished local jobs are kept for `History`
+const localHistoryRetention = 7 * 24 * time.Hour
+
+// A job of the local scheduler
+type LocalJob struct {
+	QueuedJob
+	Dir   string `json:"dir"`
+	Index int    `json:"index"`
+}
+
+// Runs simulations on the current machine, at most `Workers` at a time,
+// by executing their slurm files with output and errors written where
+// slurm would write them. Jobs run in the glurmo process that submitted
+// them, which has to `Wait` for them before exiting. The queue is kept
+// in a file shared by every glurmo process of the user, so the jobs one
+// process submits can be listed and cancelled by another.
+type LocalScheduler struct {
+	Workers  int
+	stateDir string
+	slots    chan struct{}
+	running  sync.WaitGroup
+}
+
+// Returns a local scheduler that runs up to `workers` simulations at a
+// time
+func NewLocalScheduler(workers int) (*LocalScheduler, error) {
+	if workers < 1 {
+		return nil, errorString{fmt.Sprintf("\"local_workers\" must be positive, got %d", workers)}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("could not find the local job queue: %s", err)}
+	}
+	return &LocalScheduler{
+		Workers:  workers,
+		stateDir: filepath.Join(home, ".glurmo", "local"),
+		slots:    make(chan struct{}, workers),
+	}, nil
+}
+
+// Calls `update` with the queue while holding a lock on it, then saves
+// the queue. Before `update` is called, queued jobs whose glurmo
+// process has exited are marked as lost, unless they are still running.
+// Jobs that finished too long ago for `History` are dropped.
+func (s *LocalScheduler) withQueue(update func(q *JobQueue[*LocalJob]) error) error {
+	err := os.MkdirAll(s.stateDir, 0755)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not create local job queue: %s", err)}
+	}
+
+	return UpdateJobQueue(filepath.Join(s.stateDir, "jobs.json"), "local job queue", localLost, func(q *JobQueue[*LocalJob]) error {
+		err := update(q)
+		if err != nil {
+			return err
+		}
+
+		q.prune(localHistoryRetention)
+		return nil
+	})
+}
+
+// Queues `spec` and starts running it as soon as a worker is free
+func (s *LocalScheduler) Submit(spec JobSpec) (string, error) {
+	job := LocalJob{
+		QueuedJob: QueuedJob{
+			Name:      spec.JobName(),
+			State:     JobPending,
+			Runner:    os.Getpid(),
+			Submitted: time.Now(),
+		},
+		Dir:   spec.Dir,
+		Index: spec.Index,
+	}
+	err := s.withQueue(func(q *JobQueue[*LocalJob]) error {
+		q.NextID++
+		job.ID = q.NextID
+		q.Jobs = append(q.Jobs, &job)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.running.Add(1)
+	go s.run(job.ID, spec)
+	return strconv.Itoa(job.ID), nil
+}
+
+// Runs job `id` once a worker is free, unless it is cancelled first
+func (s *LocalScheduler) run(id int, spec JobSpec) {
+	defer s.running.Done()
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
+
+	cancelled := false
+	s.withQueue(func(q *JobQueue[*LocalJob]) error {
+		job := q.find(id)
+		cancelled = job == nil || job.State != JobPending
+		return nil
+	})
+	if cancelled {
+		return
+	}
+
+	cmd, err := LocalCommand(id, spec)
+	if err != nil {
+		s.finish(id, localFailed, -1)
+		return
+	}
+	defer cmd.Stdout.(*os.File).Close()
+	defer cmd.Stderr.(*os.File).Close()
+	err = cmd.Start()
+	if err != nil {
+		s.finish(id, localFailed, -1)
+		return
+	}
+
+	s.withQueue(func(q *JobQueue[*LocalJob]) error {
+		job := q.find(id)
+		if job == nil || job.State != JobPending {
+			// Cancelled while it was starting
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+			return nil
+		}
+		job.State = JobRunning
+		job.PID = cmd.Process.Pid
+		return nil
+	})
+
+	err = cmd.Wait()
+	if err != nil {
+		s.finish(id, localFailed, cmd.ProcessState.ExitCode())
+	} else {
+		s.finish(id, localCompleted, 0)
+	}
+}
+
+// Records that job `id` finished in `state`, unless it was cancelled
+func (s *LocalScheduler) finish(id int, state string, exitCode int) {
+	s.withQueue(func(q *JobQueue[*LocalJob]) error {
+		job := q.find(id)
+		if job != nil && job.Queued() {
+			job.State = state
+			job.ExitCode = exitCode
+			job.Finished = time.Now()
+		}
+		return nil
+	})
+}
+
+// Returns the command that runs the slurm file of `spec` as local job
+// `id`, in its own process group, with its output and errors going to
+// the files slurm would write them to
+func LocalCommand(id int, spec JobSpec) (*exec.Cmd, error) {
+	indexString := strconv.Itoa(spec.Index)
+	stdout, err := os.Create(filepath.Join(spec.Dir, "slurm_out", "output___"+indexString))
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := os.Create(filepath.Join(spec.Dir, "slurm_errors", "error___"+indexString))
+	if err != nil {
+		stdout.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(spec.SlurmPath())
+	cmd.Dir = spec.Dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(),
+		"SLURM_JOB_ID="+strconv.Itoa(id),
+		"SLURM_JOB_NAME="+spec.JobName())
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd, nil
+}
+
+// Returns the local jobs that are pending or running
+func (s *LocalScheduler) List() ([]Job, error) {
+	jobs := make([]Job, 0)
+	err := s.withQueue(func(q *JobQueue[*LocalJob]) error {
+		for _, job := range q.Jobs {
+			if job.Queued() {
+				jobs = append(jobs, Job{strconv.Itoa(job.ID), job.Name, job.State})
+			}
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// Cancels local job `job`, terminating it if it is running
+func (s *LocalScheduler) Cancel(job Job) error {
+	id, err := strconv.Atoi(job.ID)
+	if err != nil {
+		return errorString{fmt.Sprintf("invalid local job id: %s", job.ID)}
+	}
+	return s.withQueue(func(q *JobQueue[*LocalJob]) error {
+		localJob := q.find(id)
+		if localJob == nil || !localJob.Queued() {
+			return errorString{fmt.Sprintf("local job %d is not queued", id)}
+		}
+		return CancelLocalJob(localJob)
+	})
+}
+
+// Marks `job` as cancelled, terminating its processes if it is running
+func CancelLocalJob(job *LocalJob) error {
+	if job.State == JobRunning {
+		err := syscall.Kill(-job.PID, syscall.SIGTERM)
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return errorString{fmt.Sprintf("could not cancel local job %d: %s", job.ID, err)}
+		}
+	}
+	job.State = localCancelled
+	job.Finished = time.Now()
+	return nil
+}
+
+// Returns the local jobs that finished since `since`
+func (s *LocalScheduler) History(since time.Time) ([]Job, error) {
+	jobs := make([]Job, 0)
+	err := s.withQueue(func(q *JobQueue[*LocalJob]) error {
+		for _, job := range q.Jobs {
+			if !job.Queued() && job.Finished.After(since) {
+				jobs = append(jobs, Job{strconv.Itoa(job.ID), job.Name, job.State})
+			}
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// Waits for the jobs submitted by this process to finish. If the
+// process is interrupted or terminated meanwhile, those jobs are
+// cancelled.
+func (s *LocalScheduler) Wait() error {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case <-done:
+		return nil
+	case sig := <-signals:
+		err := s.withQueue(func(q *JobQueue[*LocalJob]) error {
+			for _, job := range q.Jobs {
+				if job.Queued() && job.Runner == os.Getpid() {
+					err := CancelLocalJob(job)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		<-done
+		if err != nil {
+			return errorString{fmt.Sprintf("received %s, but could not cancel every local job: %s", sig, err)}
+		}
+		return errorString{fmt.Sprintf("received %s - cancelled the simulations that had not finished", sig)}
+	}
+}
@@ -1,25 +1,10 @@
 package main
 
 import (
-	"maps"
-	"reflect"
+	"cmp"
 	"slices"
 )
 
-// Given a map, returns (first key, true) if non-empty, and
-// (nil value, false) if empty
-func FirstKey[K string | int32 | int64 | float32 | float64, V any](m map[K]V) (K, bool) {
-	var nullKey K
-	if len(m) == 0 {
-		return nullKey, false
-	}
-
-	keySlice := KeySlice(m)
-	slices.Sort(keySlice)
-
-	return keySlice[0], true
-}
-
 // Returns a slice of the keys of a map
 func KeySlice[K comparable, V any](m map[K]V) []K {
 	keySlice := make([]K, 0, len(m))
@@ -29,40 +14,35 @@ func KeySlice[K comparable, V any](m map[K]V) []K {
 	return keySlice
 }
 
+// Returns the keys of a map in sorted order
+func SortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keySlice := KeySlice(m)
+	slices.Sort(keySlice)
+	return keySlice
+}
+
 // Returns a deep copy of `m`, a SettingsMap struct
 func DeepCopySettings(m SettingsMap) SettingsMap {
 	var copiedMap SettingsMap
-	copiedMap.Templates = maps.Clone(m.Templates)
-	copiedMap.General = maps.Clone(m.General)
-
-	return copiedMap
-}
-
-// Returns a deep copy of the `Slurm` property of a `SettingsMap`
-// The arbitrary structure of the `Slurm` map will be used in
-// future versions of glurmo
-func DeepCopySlurm(m map[string]interface{}) map[string]interface{} {
-	copy := make(map[string]interface{}, len(m))
-	for k, v := range m {
-		if reflect.TypeOf(v).Kind() == reflect.Map {
-			copy[k] = DeepCopySlurm(v.(map[string]interface{}))
-		} else {
-			copy[k] = v
-		}
+	copiedMap.Templates = DeepCopyMap(m.Templates)
+	copiedMap.General = DeepCopyMap(m.General)
+	for _, group := range m.Zip {
+		copiedMap.Zip = append(copiedMap.Zip, slices.Clone(group))
 	}
-	return copy
-}
-
-// Converts a `map[string]interface{}` to a `map[string]string`.
-// Fails if `m` contains non-string values
-func InterfaceToStringMap(m map[string]interface{}) (map[string]string, error) {
-	stringMap := make(map[string]string)
-	for k, v := range m {
-		if reflect.TypeOf(v).Kind() != reflect.String {
-			return nil, errorString{"map contains non-string elements"}
+	for _, exclusion := range m.Exclude {
+		copiedMap.Exclude = append(copiedMap.Exclude, DeepCopyMap(exclusion))
+	}
+	copiedMap.Constraints = slices.Clone(m.Constraints)
+	copiedMap.Format = m.Format
+	if m.Dicts != nil {
+		copiedMap.Dicts = make(map[string]map[string]map[string]any, len(m.Dicts))
+		for name, dict := range m.Dicts {
+			copiedMap.Dicts[name] = make(map[string]map[string]any, len(dict))
+			for key, overrides := range dict {
+				copiedMap.Dicts[name][key] = DeepCopyMap(overrides)
+			}
 		}
-		stringMap[k] = v.(string)
 	}
 
-	return stringMap, nil
+	return copiedMap
 }
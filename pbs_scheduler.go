@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How PBS job states map onto glurmo's. PBS reports a single letter;
+// states not listed here, e.g. F for finished or X for expired subjobs,
+// are no longer queued.
+var pbsQueuedStates = map[string]string{
+	"Q": JobPending, // queued
+	"H": JobPending, // held
+	"W": JobPending, // waiting for its start time
+	"T": JobPending, // being moved to another server
+	"R": JobRunning,
+	"E": JobRunning, // exiting after running
+	"B": JobRunning, // array job with at least one subjob running
+	"S": JobRunning, // suspended by the server
+	"U": JobRunning, // suspended because the workstation is busy
+}
+
+// The PBS Pro workload manager, driven through its command line tools.
+// Simulations are submitted with their rendered slurm file as the job
+// script, which should use #PBS directives instead of #SBATCH ones; the
+// job name, output and error paths are set by glurmo. Torque is not
+// supported, since its qstat cannot print JSON.
+type PBSScheduler struct{}
+
+// A job as reported by `qstat -f -F json`
+type pbsJob struct {
+	JobName    string `json:"Job_Name"`
+	JobOwner   string `json:"Job_Owner"`
+	JobState   string `json:"job_state"`
+	ExitStatus *int   `json:"Exit_status"`
+	Mtime      string `json:"mtime"`
+}
+
+// Submits the slurm file of `spec` with qsub
+func (PBSScheduler) Submit(spec JobSpec) (string, error) {
+	indexString := strconv.Itoa(spec.Index)
+	res, err := CommandString("qsub",
+		"-N", spec.JobName(),
+		"-o", filepath.Join(spec.Dir, "slurm_out", "output___"+indexString),
+		"-e", filepath.Join(spec.Dir, "slurm_errors", "error___"+indexString),
+		spec.SlurmPath())
+	if err != nil {
+		return "", err
+	}
+	jobID := strings.TrimSpace(res)
+	if jobID == "" || strings.ContainsAny(jobID, " \n") {
+		return "", errorString{fmt.Sprintf("unexpected output from qsub: %s", res)}
+	}
+	return jobID, nil
+}
+
+// Returns the jobs of the current user that are in the PBS queue
+func (PBSScheduler) List() ([]Job, error) {
+	pbsJobs, err := QstatJobs("-f", "-F", "json")
+	if err != nil {
+		return nil, err
+	}
+	return PBSQueuedJobs(pbsJobs), nil
+}
+
+// Returns the jobs of `pbsJobs` that are queued, in the order they were
+// submitted, with their states mapped onto glurmo's
+func PBSQueuedJobs(pbsJobs map[string]pbsJob) []Job {
+	jobs := make([]Job, 0, len(pbsJobs))
+	for _, id := range SortedPBSJobIDs(pbsJobs) {
+		pbsJob := pbsJobs[id]
+		if state, queued := pbsQueuedStates[pbsJob.JobState]; queued {
+			jobs = append(jobs, Job{id, pbsJob.JobName, state})
+		}
+	}
+	return jobs
+}
+
+// Cancels `job` with qdel
+func (PBSScheduler) Cancel(job Job) error {
+	_, err := CommandString("qdel", job.ID)
+	return err
+}
+
+// Returns the jobs of the current user that the PBS job history has
+// recorded as finished since `since`. PBS only keeps finished jobs if
+// job history is enabled on the server.
+func (PBSScheduler) History(since time.Time) ([]Job, error) {
+	pbsJobs, err := QstatJobs("-x", "-f", "-F", "json")
+	if err != nil {
+		return nil, err
+	}
+	return PBSFinishedJobs(pbsJobs, since), nil
+}
+
+// Returns the jobs of `pbsJobs` that finished since `since`, in the
+// order they were submitted, with the state they finished in
+func PBSFinishedJobs(pbsJobs map[string]pbsJob, since time.Time) []Job {
+	jobs := make([]Job, 0, len(pbsJobs))
+	for _, id := range SortedPBSJobIDs(pbsJobs) {
+		pbsJob := pbsJobs[id]
+		if pbsJob.JobState != "F" {
+			continue
+		}
+		finished, err := time.ParseInLocation(time.ANSIC, pbsJob.Mtime, time.Local)
+		if err == nil && finished.Before(since) {
+			continue
+		}
+		jobs = append(jobs, Job{id, pbsJob.JobName, PBSFinalState(pbsJob)})
+	}
+	return jobs
+}
+
+// Runs qstat with `args` and returns the jobs of the current user it
+// reports, by job id
+func QstatJobs(args ...string) (map[string]pbsJob, error) {
+	raw, err := CommandString("qstat", args...)
+	if err != nil {
+		return nil, err
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("could not get the current user: %s", err)}
+	}
+	return ParseQstatJobs(raw, currentUser.Username)
+}
+
+// Parses the output of `qstat -f -F json`, returning the jobs of user
+// `username` by job id
+func ParseQstatJobs(raw string, username string) (map[string]pbsJob, error) {
+	var qstat struct {
+		Jobs map[string]pbsJob `json:"Jobs"`
+	}
+	err := json.Unmarshal([]byte(raw), &qstat)
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("unexpected output from qstat: %s", err)}
+	}
+
+	jobs := make(map[string]pbsJob, len(qstat.Jobs))
+	for id, job := range qstat.Jobs {
+		// Job_Owner is of the format user@host
+		owner, _, _ := strings.Cut(job.JobOwner, "@")
+		if owner == username {
+			jobs[id] = job
+		}
+	}
+	return jobs, nil
+}
+
+// Returns the ids of `jobs` in the order they were submitted. PBS job
+// ids are of the format [sequence number].[server], with [] after the
+// number for array jobs.
+func SortedPBSJobIDs(jobs map[string]pbsJob) []string {
+	ids := make([]string, 0, len(jobs))
+	for id := range jobs {
+		ids = append(ids, id)
+	}
+	sequenceNumber := func(id string) int {
+		digits := strings.IndexFunc(id, func(r rune) bool { return r < '0' || r > '9' })
+		if digits == -1 {
+			digits = len(id)
+		}
+		number, _ := strconv.Atoi(id[:digits])
+		return number
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		if numberA, numberB := sequenceNumber(a), sequenceNumber(b); numberA != numberB {
+			return numberA - numberB
+		}
+		return strings.Compare(a, b)
+	})
+	return ids
+}
+
+// Returns the slurm state that best describes how the finished PBS job
+// `job` ended, based on its exit status: negative values are PBS's own
+// reasons for ending a job, and values above 256 mean the job was
+// killed by signal [value - 256].
+func PBSFinalState(job pbsJob) string {
+	switch {
+	case job.ExitStatus == nil:
+		// Deleted before it started
+		return "CANCELLED"
+	case *job.ExitStatus == 0:
+		return "COMPLETED"
+	case *job.ExitStatus == -29:
+		// JOB_EXEC_KILL_WALLTIME
+		return "TIMEOUT"
+	case *job.ExitStatus == 256+15:
+		// SIGTERM, which qdel sends
+		return "CANCELLED"
+	case *job.ExitStatus < 0:
+		return "NODE_FAIL"
+	}
+	return "FAILED"
+}
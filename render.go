@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// A simulation directory whose templates can be rendered, whether or
+// not it has been set up yet. `TemplateDir` is the directory whose
+// .glurmo subdirectory holds the templates, `SimDir` is the directory
+// the rendered paths point into, `Settings` are its settings and
+// `Levels` choose the template overrides that apply (see `GetTemplate`).
+type RenderTarget struct {
+	TemplateDir string
+	SimDir      string
+	Settings    SettingsMap
+	Levels      []string
+}
+
+// The result of rendering both templates for a single simulation
+type RenderedSimulation struct {
+	ScriptPath string
+	Script     string
+	SlurmPath  string
+	Slurm      string
+	Data       map[string]any
+}
+
+// Returns the simulation directory to render. If the settings of
+// `simDir` contain list or dict variables, `leaf` chooses one leaf of
+// the expanded grid, given relative to `simDir` (e.g. `n_100/p_10`).
+// The leaf does not need to have been set up.
+func GetRenderTarget(simDir string, leaf string) (RenderTarget, error) {
+	settingsMap, err := GetSettings(simDir)
+	if err != nil {
+		return RenderTarget{}, errorString{fmt.Sprintf("could not retrieve settings: %s", err)}
+	}
+
+	axes, err := GetGridAxes(settingsMap)
+	if err != nil {
+		return RenderTarget{}, errorString{fmt.Sprintf("could not parse script settings: %s", err)}
+	}
+	if len(axes) == 0 {
+		if leaf != "" {
+			return RenderTarget{}, usageError{fmt.Sprintf("%s has no list or dict variables, so -leaf cannot be used", simDir)}
+		}
+		return RenderTarget{TemplateDir: simDir, SimDir: simDir, Settings: settingsMap}, nil
+	}
+
+	layout, err := GetLayout(settingsMap.General)
+	if err != nil {
+		return RenderTarget{}, err
+	}
+	pruneRules, err := GetPruneRules(settingsMap, axes)
+	if err != nil {
+		return RenderTarget{}, errorString{fmt.Sprintf("could not parse script settings: %s", err)}
+	}
+	points, err := PruneGrid(ExpandGrid(axes), settingsMap.Templates, pruneRules)
+	if err != nil {
+		return RenderTarget{}, err
+	}
+	err = CheckLeafDirs(points, layout)
+	if err != nil {
+		return RenderTarget{}, err
+	}
+
+	leafDirs := make([]string, 0, len(points))
+	for _, point := range points {
+		leafDir := point.Dir(layout)
+		if leaf != "" && filepath.Clean(leaf) == leafDir {
+			return RenderTarget{
+				TemplateDir: simDir,
+				SimDir:      filepath.Join(simDir, leafDir),
+				Settings:    point.Settings(settingsMap),
+				Levels:      point.Labels,
+			}, nil
+		}
+		leafDirs = append(leafDirs, leafDir)
+	}
+
+	examples := leafDirs[:min(len(leafDirs), 3)]
+	if leaf == "" {
+		return RenderTarget{}, usageError{fmt.Sprintf("%s has list or dict variables - choose a leaf with -leaf, e.g. %s",
+			simDir, strings.Join(examples, ", "))}
+	}
+	return RenderTarget{}, usageError{fmt.Sprintf("%s is not a leaf of %s - leaves look like %s",
+		leaf, simDir, strings.Join(examples, ", "))}
+}
+
+// Renders the script and slurm templates of `target` for simulation
+// `index`, without writing anything to disk
+func RenderSimulation(target RenderTarget, index int) (RenderedSimulation, error) {
+	var rendered RenderedSimulation
+
+	nSims, err := target.Settings.NSims()
+	if err != nil {
+		return rendered, err
+	}
+	if index < 0 || index >= nSims {
+		return rendered, usageError{fmt.Sprintf("index %d is out of range - %s has %d simulations (0 to %d)",
+			index, target.SimDir, nSims, nSims-1)}
+	}
+
+	scriptTemplate, err := GetScriptTemplate(target.TemplateDir, target.Levels)
+	if err != nil {
+		return rendered, errorString{fmt.Sprintf("could not get script template: %s", err)}
+	}
+	scriptTemplate.Option("missingkey=error")
+	slurmTemplate, err := GetSlurmTemplate(target.TemplateDir, target.Levels)
+	if err != nil {
+		return rendered, errorString{fmt.Sprintf("could not get slurm template: %s", err)}
+	}
+	slurmTemplate.Option("missingkey=error")
+
+	templates, general := target.Settings.Templates, target.Settings.General
+	scriptData := ScriptTemplateData(target.SimDir, templates, general, index)
+	rendered.Script, err = RenderTemplate(&scriptTemplate, scriptData)
+	if err != nil {
+		return rendered, errorString{fmt.Sprintf("could not populate script template: %s", err)}
+	}
+
+	rendered.Data = SlurmTemplateData(target.SimDir, templates, general, index)
+	rendered.Slurm, err = RenderTemplate(&slurmTemplate, rendered.Data)
+	if err != nil {
+		return rendered, errorString{fmt.Sprintf("could not populate slurm template: %s", err)}
+	}
+
+	rendered.ScriptPath = GetString(rendered.Data, "path_to_script")
+	rendered.SlurmPath = GetString(rendered.Data, "path_to_slurm_script")
+
+	return rendered, nil
+}
+
+// Prints the keys glurmo injects into the templates of `rendered`,
+// followed by both rendered files
+func PrintRenderedSimulation(w io.Writer, rendered RenderedSimulation) {
+	injectedKeys := append(slices.Clone(scriptTemplateKeys), slurmTemplateKeys...)
+	slices.Sort(injectedKeys)
+
+	fmt.Fprintln(w, "Injected settings:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, key := range injectedKeys {
+		fmt.Fprintf(tw, "  %s\t%s\n", key, GetString(rendered.Data, key))
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n==> %s <==\n%s", rendered.ScriptPath, rendered.Script)
+	if !strings.HasSuffix(rendered.Script, "\n") {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "\n==> %s <==\n%s", rendered.SlurmPath, rendered.Slurm)
+	if !strings.HasSuffix(rendered.Slurm, "\n") {
+		fmt.Fprintln(w)
+	}
+}
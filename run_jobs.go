@@ -2,82 +2,269 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
-// Submits `nJobsToSubmit` jobs in `simDir`. If `simDir` is a "meta"
-// glurmo directory, i.e. does not actually have simulations but has
-// subdirectories that do, runs `nJobsToSubmit` in all glurmo
-// subdirectories.
-func RunJobs(simDir string, nJobsToSubmit int) (int, error) {
-	nSubmitted := 0
-	resultsExists, err := DirExists(filepath.Join(simDir, "results"))
+// How the simulations a run submits are chosen from the simulation
+// directories of a meta directory
+const (
+	// Submit as many as possible from each directory before the next
+	PolicyDepthFirst = "depth-first"
+	// Take turns submitting one simulation from each directory
+	PolicyRoundRobin = "round-robin"
+	// Split the budget in proportion to how many simulations each
+	// directory has left to submit
+	PolicyProportional = "proportional"
+)
+
+var submitPolicies = []string{PolicyDepthFirst, PolicyRoundRobin, PolicyProportional}
+
+// Options of `RunJobs`
+type RunOptions struct {
+	// One of `submitPolicies`
+	Policy string
+	// Submit up to the budget in every simulation directory, rather
+	// than in total
+	PerLeaf bool
+	// If positive, only submit as many as it takes for this many to be
+	// queued, counting those that already are
+	MaxInFlight int
+}
+
+// Returns how many simulations may be submitted when `queued` already
+// are. `nJobsToSubmit` and `maxInFlight` are ignored if they are not
+// positive, but at least one of them must be.
+func SubmissionBudget(nJobsToSubmit int, maxInFlight int, queued int) int {
+	if maxInFlight <= 0 {
+		return nJobsToSubmit
+	}
+	budget := max(0, maxInFlight-queued)
+	if nJobsToSubmit > 0 {
+		budget = min(budget, nJobsToSubmit)
+	}
+	return budget
+}
+
+// The simulations of a simulation directory that are neither completed
+// nor currently submitted, in the order they are submitted, along with
+// the number of its jobs that are currently queued
+type SubmittableSims struct {
+	Dir     string
+	SimID   string
+	Indices []int
+	Queued  int
+}
+
+// Submits up to `nJobsToSubmit` simulations that are neither completed
+// nor currently submitted in `simDir`. If `simDir` is a "meta" glurmo
+// directory, i.e. does not actually have simulations but has
+// subdirectories that do, `nJobsToSubmit` is a budget for the whole
+// tree, distributed among its simulation directories by
+// `options.Policy`, unless `options.PerLeaf` is set, in which case up
+// to `nJobsToSubmit` are submitted in every simulation directory. If
+// `options.MaxInFlight` is set, jobs that are already queued count
+// against it, and `nJobsToSubmit` may be zero for no further limit.
+func RunJobs(w io.Writer, sched Scheduler, simDir string, nJobsToSubmit int, options RunOptions) (int, error) {
+	leafDirs, err := GetLeafDirs(simDir)
 	if err != nil {
 		return 0, errorString{fmt.Sprintf("failed to submit jobs: %s", err)}
 	}
+	if len(leafDirs) == 0 {
+		return 0, errorString{fmt.Sprintf("no simulation directories found in %s - has it been set up?", simDir)}
+	}
 
-	if !resultsExists {
-		// Recursively check for glurmo subdirectories
-		allSubdirs, err := GetSubdirs(simDir)
+	allJobs, err := sched.List()
+	if err != nil {
+		return 0, errorString{fmt.Sprintf("could not retrieve queued jobs: %s", err)}
+	}
+
+	submittable := make([]SubmittableSims, 0, len(leafDirs))
+	for _, leafDir := range leafDirs {
+		sims, err := GetSubmittableSims(leafDir, allJobs)
 		if err != nil {
-			return 0, errorString{fmt.Sprintf("failed to submit jobs: %s", err)}
+			return 0, err
 		}
-
-		for _, subdir := range allSubdirs {
-			if subdir == ".glurmo" {
-				continue
-			}
-			submittedJobs, err := RunJobs(filepath.Join(simDir, subdir), nJobsToSubmit)
-			if err != nil {
-				return nSubmitted, err
-			}
-			nSubmitted += submittedJobs
+		submittable = append(submittable, sims)
+	}
+	if options.MaxInFlight > 0 {
+		queued := 0
+		for _, sims := range submittable {
+			queued += sims.Queued
 		}
-
-		return nSubmitted, nil
-	} else {
-		settingsMap, err := GetSettings(simDir)
-		if err != nil {
-			return nSubmitted, errorString{fmt.Sprintf("failed to submit jobs in directory `%s`: %s", simDir, err)}
+		if options.PerLeaf {
+			fmt.Fprintf(w, "%d jobs are already queued, out of at most %d in each simulation directory\n", queued, options.MaxInFlight)
+		} else {
+			fmt.Fprintf(w, "%d jobs are already queued, out of at most %d\n", queued, options.MaxInFlight)
 		}
+	}
 
-		_, submittedMap, err := GetNumberSubmitted(settingsMap.General["id"])
-		if err != nil {
-			return nSubmitted, err
-		}
-		_, completedMap, err := GetNumberCompleted(simDir, settingsMap.Templates["result_extension"])
+	nSubmitted := 0
+	for _, spec := range PlanSubmissions(submittable, nJobsToSubmit, options) {
+		_, err := sched.Submit(spec)
 		if err != nil {
 			return nSubmitted, errorString{fmt.Sprintf("failed to submit jobs: %s", err)}
 		}
+		nSubmitted += 1
+	}
+
+	return nSubmitted, nil
+}
 
-		slurmDir := filepath.Join(simDir, "slurm")
-		jobSlice, err := os.ReadDir(slurmDir)
-		nJobs := len(jobSlice)
+// Returns the simulations of the simulation directory `simDir` that are
+// neither completed nor among `allJobs`, the jobs currently queued
+func GetSubmittableSims(simDir string, allJobs []Job) (SubmittableSims, error) {
+	sims := SubmittableSims{Dir: simDir, Indices: make([]int, 0)}
 
+	settingsMap, err := GetSettings(simDir)
+	if err != nil {
+		return sims, errorString{fmt.Sprintf("failed to submit jobs in directory `%s`: %s", simDir, err)}
+	}
+	sims.SimID = settingsMap.ID()
+	nJobs, err := settingsMap.NSims()
+	if err != nil {
+		return sims, errorString{fmt.Sprintf("failed to submit jobs in directory `%s`: %s", simDir, err)}
+	}
+	_, completedMap, err := GetNumberCompleted(simDir, GetString(settingsMap.Templates, "result_extension"))
+	if err != nil {
+		return sims, errorString{fmt.Sprintf("failed to submit jobs: %s", err)}
+	}
+
+	submittedMap := make(map[int]bool)
+	for _, job := range FilterSimJobs(allJobs, sims.SimID) {
+		jobNum, err := GetJobNumber(job.JobName)
 		if err != nil {
-			return nSubmitted, errorString{fmt.Sprintf("failed to submit jobs: %s", err)}
+			return sims, errorString{fmt.Sprintf("could not retrieve queued jobs: %s", err)}
 		}
+		submittedMap[jobNum] = true
+	}
+	sims.Queued = len(submittedMap)
 
-		curJobNum := 0
+	for i := 0; i < nJobs; i++ {
+		if !completedMap[i] && !submittedMap[i] {
+			sims.Indices = append(sims.Indices, i)
+		}
+	}
+	return sims, nil
+}
 
-		for nSubmitted < nJobsToSubmit && curJobNum < nJobs {
-			if !completedMap[curJobNum] && !submittedMap[curJobNum] {
-				res, err := CommandString("sbatch", filepath.Join(slurmDir, "slurm_"+fmt.Sprint(curJobNum)))
-				if err != nil {
-					return 0, errorString{fmt.Sprintf("failed to submit jobs: %s", err)}
-				}
-				if strings.HasPrefix(res, "Submitted batch job") {
-					nSubmitted += 1
+// Chooses which of `submittable` to submit, given a budget of
+// `nJobsToSubmit` and `options.MaxInFlight` (see `SubmissionBudget`),
+// and returns them in the order they should be submitted
+func PlanSubmissions(submittable []SubmittableSims, nJobsToSubmit int, options RunOptions) []JobSpec {
+	counts := make([]int, len(submittable))
+	queued := 0
+	for i, sims := range submittable {
+		counts[i] = len(sims.Indices)
+		queued += sims.Queued
+	}
+
+	budget := SubmissionBudget(nJobsToSubmit, options.MaxInFlight, queued)
+	var allocation []int
+	switch {
+	case options.PerLeaf:
+		allocation = make([]int, len(counts))
+		for i, count := range counts {
+			allocation[i] = min(count, SubmissionBudget(nJobsToSubmit, options.MaxInFlight, submittable[i].Queued))
+		}
+	case options.Policy == PolicyProportional:
+		allocation = AllocateProportional(counts, budget)
+	case options.Policy == PolicyRoundRobin:
+		allocation = AllocateRoundRobin(counts, budget)
+	default:
+		allocation = AllocateDepthFirst(counts, budget)
+	}
+
+	jobs := make([]JobSpec, 0, Sum(allocation))
+	if options.Policy == PolicyRoundRobin {
+		for round := 0; len(jobs) < Sum(allocation); round++ {
+			for i, sims := range submittable {
+				if round < allocation[i] {
+					jobs = append(jobs, JobSpec{sims.Dir, sims.SimID, sims.Indices[round]})
 				}
 			}
-			curJobNum += 1
 		}
+		return jobs
+	}
+	for i, sims := range submittable {
+		for _, index := range sims.Indices[:allocation[i]] {
+			jobs = append(jobs, JobSpec{sims.Dir, sims.SimID, index})
+		}
+	}
+	return jobs
+}
+
+// Splits `budget` among directories with `counts` simulations left,
+// filling each directory before moving on to the next
+func AllocateDepthFirst(counts []int, budget int) []int {
+	allocation := make([]int, len(counts))
+	for i, count := range counts {
+		allocation[i] = min(count, budget)
+		budget -= allocation[i]
+	}
+	return allocation
+}
+
+// Splits `budget` among directories with `counts` simulations left,
+// one simulation per directory at a time
+func AllocateRoundRobin(counts []int, budget int) []int {
+	allocation := make([]int, len(counts))
+	for budget > 0 {
+		allocated := false
+		for i, count := range counts {
+			if budget > 0 && allocation[i] < count {
+				allocation[i]++
+				budget--
+				allocated = true
+			}
+		}
+		if !allocated {
+			break
+		}
+	}
+	return allocation
+}
+
+// Splits `budget` among directories with `counts` simulations left, in
+// proportion to `counts`. Shares are rounded down, and what is left is
+// given to the directories with the largest remainders, earlier
+// directories first.
+func AllocateProportional(counts []int, budget int) []int {
+	allocation := make([]int, len(counts))
+	total := Sum(counts)
+	if total <= budget {
+		copy(allocation, counts)
+		return allocation
+	}
 
-		return nSubmitted, nil
+	remainders := make([]int, len(counts))
+	for i, count := range counts {
+		allocation[i] = budget * count / total
+		remainders[i] = budget * count % total
 	}
+	order := make([]int, len(counts))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a int, b int) int {
+		return remainders[b] - remainders[a]
+	})
+	for _, i := range order[:budget-Sum(allocation)] {
+		allocation[i]++
+	}
+	return allocation
+}
 
+// Returns the sum of `values`
+func Sum(values []int) int {
+	total := 0
+	for _, value := range values {
+		total += value
+	}
+	return total
 }
 
 func GetNumberCompleted(simDir string, resultExtension string) (int, map[int]bool, error) {
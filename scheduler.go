@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// States of queued jobs that every scheduler reports. Schedulers may
+// report other states, which are treated as running.
+const (
+	JobPending = "PENDING"
+	JobRunning = "RUNNING"
+)
+
+// A job known to a scheduler. `ID` is the job id, `JobName` is the job
+// name, of the format [simulation name]___[job number], and `State` is
+// the current state of the job.
+type Job struct {
+	ID      string
+	JobName string
+	State   string
+}
+
+// A simulation to submit: simulation `Index` of the simulation
+// directory `Dir`, whose settings have id `SimID`
+type JobSpec struct {
+	Dir   string
+	SimID string
+	Index int
+}
+
+// Returns the name of the job of `spec`, the same as its `job_id`
+// template value
+func (spec JobSpec) JobName() string {
+	return spec.SimID + "___" + strconv.Itoa(spec.Index)
+}
+
+// Returns the path of the rendered slurm file of `spec`
+func (spec JobSpec) SlurmPath() string {
+	return filepath.Join(spec.Dir, "slurm", "slurm_"+strconv.Itoa(spec.Index))
+}
+
+// A workload manager that glurmo submits simulations to
+type Scheduler interface {
+	// Submits `spec`, returning the id of the new job
+	Submit(spec JobSpec) (string, error)
+	// Returns the jobs of the current user that are queued, i.e.
+	// pending or running
+	List() ([]Job, error)
+	// Cancels the queued job `job`
+	Cancel(job Job) error
+	// Returns the jobs of the current user that have finished since
+	// `since`, with the state they finished in, e.g. COMPLETED or
+	// FAILED. The autopilot uses it to report failed jobs.
+	History(since time.Time) ([]Job, error)
+}
+
+// A scheduler that runs the jobs submitted to it in the current
+// process, which has to wait for them to finish before exiting
+type Waiter interface {
+	Wait() error
+}
+
+// Names of the schedulers the "scheduler" general setting can select
+const (
+	slurmSchedulerName  = "slurm"
+	localSchedulerName  = "local"
+	pbsSchedulerName    = "pbs"
+	condorSchedulerName = "condor"
+)
+
+// Returns the scheduler requested by the "scheduler" general setting;
+// defaults to slurm. The local scheduler runs as many simulations at a
+// time as the "local_workers" general setting, or as there are CPUs.
+func NewScheduler(generalSettings map[string]any) (Scheduler, error) {
+	name := slurmSchedulerName
+	if _, hasKey := generalSettings["scheduler"]; hasKey {
+		name = GetString(generalSettings, "scheduler")
+	}
+
+	switch name {
+	case slurmSchedulerName:
+		return SlurmScheduler{}, nil
+	case localSchedulerName:
+		workers := runtime.NumCPU()
+		if workersValue, hasKey := generalSettings["local_workers"]; hasKey {
+			var err error
+			workers, err = AsInt(workersValue)
+			if err != nil {
+				return nil, errorString{fmt.Sprintf("\"local_workers\" must be an integer: %s", err)}
+			}
+		}
+		return NewLocalScheduler(workers)
+	case pbsSchedulerName:
+		return PBSScheduler{}, nil
+	case condorSchedulerName:
+		return CondorScheduler{}, nil
+	}
+	return nil, errorString{fmt.Sprintf("\"scheduler\" must be one of \"%s\", \"%s\", \"%s\" or \"%s\", got \"%s\"",
+		slurmSchedulerName, localSchedulerName, pbsSchedulerName, condorSchedulerName, name)}
+}
+
+// Returns the scheduler that manages the simulations of `simDir`, as
+// set in its settings. A meta directory without settings of its own
+// uses the settings of its first simulation directory, and one without
+// any simulations uses slurm.
+func GetScheduler(simDir string) (Scheduler, error) {
+	settingsDir := simDir
+	if !DirHasSettings(simDir) {
+		leafDirs, err := GetLeafDirs(simDir)
+		if err != nil {
+			return nil, errorString{fmt.Sprintf("could not find simulations in %s: %s", simDir, err)}
+		}
+		if len(leafDirs) == 0 {
+			return SlurmScheduler{}, nil
+		}
+		settingsDir = leafDirs[0]
+	}
+
+	settingsMap, err := GetSettings(settingsDir)
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("could not retrieve settings: %s", err)}
+	}
+	return NewScheduler(settingsMap.General)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// A scheduler that keeps its queue in memory and never runs anything
+type memScheduler struct {
+	queue     []Job
+	history   []Job
+	nextID    int
+	submitted []JobSpec
+	cancelled []string
+}
+
+func (s *memScheduler) Submit(spec JobSpec) (string, error) {
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	s.queue = append(s.queue, Job{id, spec.JobName(), JobPending})
+	s.submitted = append(s.submitted, spec)
+	return id, nil
+}
+
+func (s *memScheduler) List() ([]Job, error) {
+	return slices.Clone(s.queue), nil
+}
+
+func (s *memScheduler) Cancel(job Job) error {
+	s.queue = slices.DeleteFunc(s.queue, func(queued Job) bool { return queued.ID == job.ID })
+	s.cancelled = append(s.cancelled, job.JobName)
+	return nil
+}
+
+func (s *memScheduler) History(since time.Time) ([]Job, error) {
+	return slices.Clone(s.history), nil
+}
+
+// Returns the simulation ids of the leaves `n_1` and `n_2` of a grid
+// set up by `setupTestGrid`
+func testGridIDs(t *testing.T, simDir string) (string, string) {
+	ids := make([]string, 0, 2)
+	for _, leaf := range []string{"n_1", "n_2"} {
+		settingsMap, err := GetSettings(filepath.Join(simDir, leaf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, settingsMap.ID())
+	}
+	return ids[0], ids[1]
+}
+
+// Writes the result of simulation `index` of `leafDir`, named exactly
+// like the `results_path` glurmo injects
+func writeTestResult(t *testing.T, leafDir string, index int) {
+	path := filepath.Join(leafDir, "results", "results___"+strconv.Itoa(index))
+	if err := os.WriteFile(path, []byte("done\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns the job names of `specs`
+func specNames(specs []JobSpec) []string {
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		names = append(names, spec.JobName())
+	}
+	return names
+}
+
+func TestPlanSubmissions(t *testing.T) {
+	submittable := []SubmittableSims{
+		{Dir: "a", SimID: "a", Indices: []int{0, 1, 2, 3, 4, 5}},
+		{Dir: "b", SimID: "b", Indices: []int{0, 1}, Queued: 1},
+		{Dir: "c", SimID: "c", Indices: []int{2, 3, 4}, Queued: 2},
+	}
+	cases := []struct {
+		name    string
+		n       int
+		options RunOptions
+		want    []string
+	}{
+		{"depth-first", 7, RunOptions{Policy: PolicyDepthFirst},
+			[]string{"a___0", "a___1", "a___2", "a___3", "a___4", "a___5", "b___0"}},
+		{"round-robin", 6, RunOptions{Policy: PolicyRoundRobin},
+			[]string{"a___0", "b___0", "c___2", "a___1", "b___1", "c___3"}},
+		{"proportional", 5, RunOptions{Policy: PolicyProportional},
+			[]string{"a___0", "a___1", "a___2", "b___0", "c___2"}},
+		{"more than there is", 100, RunOptions{Policy: PolicyProportional},
+			[]string{"a___0", "a___1", "a___2", "a___3", "a___4", "a___5", "b___0", "b___1", "c___2", "c___3", "c___4"}},
+		{"per-leaf", 2, RunOptions{PerLeaf: true},
+			[]string{"a___0", "a___1", "b___0", "b___1", "c___2", "c___3"}},
+		{"max-in-flight", 0, RunOptions{MaxInFlight: 5},
+			[]string{"a___0", "a___1"}},
+		{"max-in-flight and n", 1, RunOptions{MaxInFlight: 5},
+			[]string{"a___0"}},
+		{"max-in-flight already reached", 0, RunOptions{MaxInFlight: 3},
+			[]string{}},
+		{"per-leaf max-in-flight", 0, RunOptions{PerLeaf: true, MaxInFlight: 2},
+			[]string{"a___0", "a___1", "b___0"}},
+	}
+	for _, c := range cases {
+		got := specNames(PlanSubmissions(submittable, c.n, c.options))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: planned %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	simDir := setupTestGrid(t)
+	id1, id2 := testGridIDs(t, simDir)
+	writeTestResult(t, filepath.Join(simDir, "n_1"), 0)
+	sched := &memScheduler{queue: []Job{{"100", id2 + "___1", JobRunning}}, nextID: 100}
+
+	nSubmitted, err := RunJobs(io.Discard, sched, simDir, 5, RunOptions{Policy: PolicyRoundRobin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{id1 + "___1", id2 + "___0"}
+	if got := specNames(sched.submitted); nSubmitted != 2 || !reflect.DeepEqual(got, want) {
+		t.Errorf("submitted %d: %v, want %v", nSubmitted, got, want)
+	}
+
+	// Every simulation is now either completed or queued
+	sched.submitted = nil
+	nSubmitted, err = RunJobs(io.Discard, sched, simDir, 5, RunOptions{})
+	if err != nil || nSubmitted != 0 {
+		t.Errorf("submitted %d (%v) with nothing left to submit, want 0", nSubmitted, err)
+	}
+}
+
+func TestRunJobsMaxInFlight(t *testing.T) {
+	simDir := setupTestGrid(t)
+	id1, id2 := testGridIDs(t, simDir)
+	sched := &memScheduler{queue: []Job{{"1", id1 + "___0", JobRunning}}, nextID: 1}
+
+	nSubmitted, err := RunJobs(io.Discard, sched, simDir, 0, RunOptions{MaxInFlight: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{id1 + "___1", id2 + "___0"}; nSubmitted != 2 || !reflect.DeepEqual(specNames(sched.submitted), want) {
+		t.Errorf("submitted %d: %v, want %v", nSubmitted, specNames(sched.submitted), want)
+	}
+
+	// The queue is full
+	nSubmitted, err = RunJobs(io.Discard, sched, simDir, 0, RunOptions{MaxInFlight: 3})
+	if err != nil || nSubmitted != 0 {
+		t.Errorf("submitted %d (%v) to a full queue, want 0", nSubmitted, err)
+	}
+}
+
+func TestCheckSimStatus(t *testing.T) {
+	simDir := setupTestGrid(t)
+	id1, id2 := testGridIDs(t, simDir)
+	writeTestResult(t, filepath.Join(simDir, "n_1"), 0)
+	sched := &memScheduler{queue: []Job{
+		// Completed jobs that are still queued count as completed
+		{"1", id1 + "___0", JobRunning},
+		{"2", id2 + "___0", JobPending},
+		{"3", id2 + "___1", "COMPLETING"},
+		// Other simulations are ignored, even if their name starts
+		// with the same id
+		{"4", id1 + "0___1", JobRunning},
+	}}
+
+	statuses, err := CheckSimStatus(sched, simDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SimStatus{
+		{Dir: filepath.Join(simDir, "n_1"), NSims: 2, Completed: 1, Running: 0, Pending: 0, NotSubmitted: 1},
+		{Dir: filepath.Join(simDir, "n_2"), NSims: 2, Completed: 0, Running: 1, Pending: 1, NotSubmitted: 0},
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("status %+v, want %+v", statuses, want)
+	}
+}
+
+func TestCancelJobs(t *testing.T) {
+	simDir := setupTestGrid(t)
+	id1, id2 := testGridIDs(t, simDir)
+	sched := &memScheduler{queue: []Job{
+		{"1", id1 + "___0", JobRunning},
+		{"2", id1 + "___1", JobPending},
+		{"3", id2 + "___0", JobPending},
+		{"4", id2 + "___1", JobPending},
+		{"5", "other___0", JobPending},
+	}}
+
+	nCancelled, err := CancelJobs(sched, simDir, 1, map[string]bool{JobPending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{id1 + "___1", id2 + "___0"}; nCancelled != 2 || !reflect.DeepEqual(sched.cancelled, want) {
+		t.Errorf("cancelled %d: %v, want %v", nCancelled, sched.cancelled, want)
+	}
+
+	sched.cancelled = nil
+	nCancelled, err = CancelJobs(sched, simDir, 10, map[string]bool{JobPending: true, JobRunning: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{id1 + "___0", id2 + "___1"}; nCancelled != 2 || !reflect.DeepEqual(sched.cancelled, want) {
+		t.Errorf("cancelled %d: %v, want %v", nCancelled, sched.cancelled, want)
+	}
+}
+
+func TestGetSchedulerMetaDir(t *testing.T) {
+	metaDir := t.TempDir()
+	scheduler, err := GetScheduler(metaDir)
+	if _, isSlurm := scheduler.(SlurmScheduler); err != nil || !isSlurm {
+		t.Errorf("empty meta directory uses %T (%v), want slurm", scheduler, err)
+	}
+
+	leafDir := filepath.Join(metaDir, "grid")
+	for _, dir := range []string{filepath.Join(leafDir, ".glurmo"), filepath.Join(leafDir, "results")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	settings := `{"general": {"id": "g", "n_sims": 1, "scheduler": "pbs"}, "templates": {}}`
+	if err := os.WriteFile(filepath.Join(leafDir, ".glurmo", "settings.json"), []byte(settings), 0644); err != nil {
+		t.Fatal(err)
+	}
+	scheduler, err = GetScheduler(metaDir)
+	if _, isPBS := scheduler.(PBSScheduler); err != nil || !isPBS {
+		t.Errorf("meta directory uses %T (%v), want the pbs scheduler of its simulations", scheduler, err)
+	}
+
+	// A results link that cannot be followed makes the leaves unknowable
+	brokenDir := filepath.Join(metaDir, "broken")
+	if err := os.Mkdir(brokenDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("results", filepath.Join(brokenDir, "results")); err != nil {
+		t.Fatal(err)
+	}
+	if scheduler, err := GetScheduler(metaDir); err == nil {
+		t.Errorf("meta directory with unreadable leaves uses %T, want an error", scheduler)
+	}
+	if scheduler, err := GetScheduler(filepath.Join(metaDir, "missing")); err == nil {
+		t.Errorf("missing directory uses %T, want an error", scheduler)
+	}
+}
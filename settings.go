@@ -1,16 +1,170 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 // A struct representing the settings file for a given
-// simulation.
+// simulation. Settings may be any JSON value: strings, numbers,
+// booleans, arrays or objects. Numbers are read as `int64` if they
+// are integers and as `float64` otherwise, so templates can compare
+// and compute with them directly.
+//
+// In the "templates" section, arrays are list variables, as are
+// strings of the form `@[v_1, ..., v_n]`. Objects whose values are
+// all objects are "dict" variables, and are stored in `Dicts` rather
+// than `Templates`: each key of the object is a value of the
+// variable, mapped to the template settings that value brings with
+// it, e.g.
+//
+//	"method": {"lasso": {"mem": "4G"}, "ridge": {"mem": "16G"}}
+//
+// Any other object is passed to the templates as is.
+//
+// `Zip` lists groups of list variables that vary together rather
+// than being crossed, e.g. `"zip": [["n", "mem"]]`. `Exclude` and
+// `Constraints` remove combinations of list and dict variables:
+// exclusion rules such as `{"method": "ols", "p": 1000}` skip every
+// combination matching all of their values, and constraints such as
+// `"p < n"` skip every combination for which they are false.
+//
+// `Format` is the format of the settings file the settings were
+// read from ("json", "yaml", "yml" or "toml").
 type SettingsMap struct {
-	General   map[string]string `json:"general"`
-	Templates map[string]string `json:"templates"`
+	General     map[string]any
+	Templates   map[string]any
+	Dicts       map[string]map[string]map[string]any
+	Zip         [][]string
+	Exclude     []map[string]any
+	Constraints []string
+	Format      string
+}
+
+// The layout of a settings file on disk
+type settingsFile struct {
+	General     map[string]any   `json:"general" yaml:"general" toml:"general"`
+	Templates   map[string]any   `json:"templates" yaml:"templates" toml:"templates"`
+	Zip         [][]string       `json:"zip,omitempty" yaml:"zip,omitempty" toml:"zip,omitempty"`
+	Exclude     []map[string]any `json:"exclude,omitempty" yaml:"exclude,omitempty" toml:"exclude,omitempty"`
+	Constraints []string         `json:"constraints,omitempty" yaml:"constraints,omitempty" toml:"constraints,omitempty"`
+}
+
+// Reads a settings file, separating dict variables from the other
+// template settings
+func (s *SettingsMap) UnmarshalJSON(data []byte) error {
+	var raw settingsFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&raw)
+	if err != nil {
+		return err
+	}
+
+	return s.fromFile(raw)
+}
+
+// Fills in `s` from a decoded settings file
+func (s *SettingsMap) fromFile(raw settingsFile) error {
+	s.General = NormalizeMap(raw.General)
+	s.Zip = raw.Zip
+	s.Constraints = raw.Constraints
+	s.Exclude = make([]map[string]any, 0, len(raw.Exclude))
+	for _, exclusion := range raw.Exclude {
+		s.Exclude = append(s.Exclude, NormalizeMap(exclusion))
+	}
+
+	s.Templates = make(map[string]any, len(raw.Templates))
+	s.Dicts = make(map[string]map[string]map[string]any)
+	for k, v := range NormalizeMap(raw.Templates) {
+		dict, isDict := AsDict(v)
+		if isDict {
+			s.Dicts[k] = dict
+		} else {
+			s.Templates[k] = v
+		}
+	}
+
+	return nil
+}
+
+// Converts `s` to the layout of a settings file, putting dict
+// variables back into the "templates" section
+func (s SettingsMap) toFile() settingsFile {
+	templates := make(map[string]any, len(s.Templates)+len(s.Dicts))
+	for k, v := range s.Templates {
+		templates[k] = v
+	}
+	for k, v := range s.Dicts {
+		templates[k] = v
+	}
+
+	var exclude []map[string]any
+	if len(s.Exclude) > 0 {
+		exclude = s.Exclude
+	}
+
+	return settingsFile{s.General, templates, s.Zip, exclude, s.Constraints}
+}
+
+// Writes a settings file
+func (s SettingsMap) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.toFile())
+}
+
+// If `v` is an object whose values are all objects, returns it as
+// a dict variable
+func AsDict(v any) (map[string]map[string]any, bool) {
+	object, isObject := v.(map[string]any)
+	if !isObject || len(object) == 0 {
+		return nil, false
+	}
+
+	dict := make(map[string]map[string]any, len(object))
+	for key, settings := range object {
+		settingsObject, isObject := settings.(map[string]any)
+		if !isObject {
+			return nil, false
+		}
+		dict[key] = settingsObject
+	}
+
+	return dict, true
+}
+
+// Returns the format of the settings file, defaulting to JSON for
+// settings that were not read from a file
+func (s SettingsMap) FormatOrDefault() string {
+	if s.Format == "" {
+		return "json"
+	}
+	return s.Format
+}
+
+// Returns the simulation id (`id` in the "general" section)
+func (s SettingsMap) ID() string {
+	return GetString(s.General, "id")
+}
+
+// Returns the number of simulations (`n_sims` in the "general"
+// section) as an integer
+func (s SettingsMap) NSims() (int, error) {
+	nSimsValue, hasKey := s.General["n_sims"]
+	if !hasKey {
+		return 0, errorString{"\"n_sims\" must be specified in \"general\" section of the settings file"}
+	}
+	nSims, err := AsInt(nSimsValue)
+	if err != nil {
+		return 0, errorString{fmt.Sprintf("\"n_sims\" must be an integer: %s", err)}
+	}
+	return nSims, nil
 }
 
 // Retrieves the `SettingsMap` for a given simulation.
@@ -61,59 +215,130 @@ func GetSettingsDir(simDir string) (string, error) {
 	return settingsDir, nil
 }
 
-// Given a path to a simulation `simDir`, returns the path
-// to the settings file `simDir/.glurmo/settings.json`
+// The settings file formats glurmo understands, identified by the
+// extension of the settings file
+var settingsFormats = []string{"json", "yaml", "yml", "toml"}
+
+// Given the path to the .glurmo directory `settingsDir`, returns the
+// path to the settings file, which is one of `settings.json`,
+// `settings.yaml`, `settings.yml` or `settings.toml`. It is an error
+// for more than one of these to exist.
 func GetSettingsFile(settingsDir string) (string, error) {
 	settingsDirFiles, err := os.ReadDir(settingsDir)
 	if err != nil {
 		return "", err
 	}
 
-	hasSettingsFile := false
-
+	settingsFiles := make([]string, 0, 1)
 	for _, f := range settingsDirFiles {
-		hasSettingsFile = hasSettingsFile || (f.Name() == "settings.json" && !f.IsDir())
-		if hasSettingsFile {
-			break
+		for _, format := range settingsFormats {
+			if f.Name() == SettingsFileName(format) && !f.IsDir() {
+				settingsFiles = append(settingsFiles, f.Name())
+			}
 		}
 	}
 
-	if !hasSettingsFile {
-		return "", errorString{s: "could not find settings file (settings.json) in directory " + settingsDir}
+	if len(settingsFiles) == 0 {
+		return "", errorString{s: "could not find settings file (settings.json, settings.yaml, settings.yml " +
+			"or settings.toml) in directory " + settingsDir}
+	}
+	if len(settingsFiles) > 1 {
+		return "", errorString{s: fmt.Sprintf("found more than one settings file in directory %s (%s) - "+
+			"please keep only one", settingsDir, strings.Join(settingsFiles, ", "))}
 	}
 
-	settingsFile := filepath.Join(settingsDir, "settings.json")
+	settingsFile := filepath.Join(settingsDir, settingsFiles[0])
 
 	return settingsFile, nil
 }
 
-// Given the path to a settings file `settings.json`, reads it
-// in as a SettingsMap struct
-func GetSettingsMap(settingsFile string) (SettingsMap, error) {
-	var settingsMap SettingsMap
-	rawBytes, err := os.ReadFile(settingsFile)
+// Returns the name of the settings file in format `format`
+func SettingsFileName(format string) string {
+	return "settings." + format
+}
+
+// Given the path to a settings file, reads it in as a SettingsMap
+// struct. The format of the file is determined by its extension.
+func GetSettingsMap(settingsPath string) (SettingsMap, error) {
+	rawBytes, err := os.ReadFile(settingsPath)
 	if err != nil {
-		return settingsMap, err
+		return SettingsMap{}, err
+	}
+	settingsMap, err := ParseSettings(rawBytes, strings.TrimPrefix(filepath.Ext(settingsPath), "."))
+	if err != nil {
+		return settingsMap, errorString{fmt.Sprintf("could not read %s: %s", settingsPath, err)}
+	}
+	return settingsMap, nil
+}
+
+// Parses the contents of a settings file in format `format`
+func ParseSettings(rawBytes []byte, format string) (SettingsMap, error) {
+	var settingsMap SettingsMap
+	var err error
+	switch format {
+	case "json":
+		err = json.Unmarshal(rawBytes, &settingsMap)
+	case "yaml", "yml":
+		var raw settingsFile
+		err = yaml.Unmarshal(rawBytes, &raw)
+		if err == nil {
+			err = settingsMap.fromFile(raw)
+		}
+	case "toml":
+		var raw settingsFile
+		err = toml.Unmarshal(rawBytes, &raw)
+		if err == nil {
+			err = settingsMap.fromFile(raw)
+		}
+	default:
+		err = errorString{fmt.Sprintf("unknown settings file format `%s`", format)}
 	}
-	err = json.Unmarshal(rawBytes, &settingsMap)
 	if err != nil {
 		return settingsMap, err
 	}
+	settingsMap.Format = format
 
 	return settingsMap, nil
 }
 
-// Ensures that the SettingsMap is well formed
-func CheckSettingsDicts(script_dict map[string]string, slurm_dict map[string]string) error {
-	// Script must have extension
-	if _, extension_set := script_dict["extension"]; !extension_set {
-		return errorString{s: "script settings must specify " +
-			"script file extension with `extension` setting"}
+// Encodes `settingsMap` in its format (`settingsMap.Format`)
+func MarshalSettings(settingsMap SettingsMap) ([]byte, error) {
+	switch settingsMap.Format {
+	case "yaml", "yml":
+		return yaml.Marshal(settingsMap.toFile())
+	case "toml":
+		var buf bytes.Buffer
+		err := toml.NewEncoder(&buf).Encode(settingsMap.toFile())
+		return buf.Bytes(), err
+	default:
+		return json.MarshalIndent(settingsMap, "", "\t")
 	}
-	// slurm must have simulation_id
-	if _, id_set := slurm_dict["simulation_id"]; !id_set {
-		return errorString{s: "slurm settings must specify " +
-			"simulation set id with `simulation_id` setting"}
+}
+
+// Stages writing `settingsMap` to the .glurmo subdirectory of `simDir`, in
+// the format of `settingsMap`. Settings files in other formats are
+// removed, so `simDir` is left with exactly one settings file.
+func WriteSettings(t *Transaction, simDir string, settingsMap SettingsMap) error {
+	settingsBytes, err := MarshalSettings(settingsMap)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not encode settings of %s: %s", simDir, err)}
+	}
+
+	settingsDir := filepath.Join(simDir, ".glurmo")
+	fileName := SettingsFileName(settingsMap.FormatOrDefault())
+	for _, format := range settingsFormats {
+		if SettingsFileName(format) == fileName {
+			continue
+		}
+		err = t.Remove(filepath.Join(settingsDir, SettingsFileName(format)))
+		if err != nil {
+			return errorString{fmt.Sprintf("could not remove old settings of %s: %s", simDir, err)}
+		}
+	}
+
+	err = t.WriteFile(filepath.Join(settingsDir, fileName), settingsBytes)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not write settings of %s: %s", simDir, err)}
 	}
 
 	return nil
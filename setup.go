@@ -1,280 +1,440 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"os"
+	"io"
+	"maps"
 	"path/filepath"
-	"regexp"
-	"strconv"
+	"slices"
 	"strings"
+	"text/template"
 )
 
-// Given the path to a glurmo directory, a SettingsMap, and an indication of whether
-// or not to check if the directory is empty, sets up the infrastructure of the
-// glurmo directory
-func SetupDir(simDir string, settingsMap SettingsMap, checkEmpty bool) error {
-	if checkEmpty {
-		isEmpty, contents, err := CheckIfEmpty(simDir)
-		if err != nil {
-			return errorString{fmt.Sprintf("could not complete setup: %s", err)}
-		}
+// Options controlling how `SetupDir` treats existing contents
+type SetupOptions struct {
+	// Keep existing leaves, only creating new ones and rewriting the
+	// files whose inputs changed
+	Incremental bool
+	// With Incremental, also remove leaves that are no longer part of
+	// the grid, and the results and logs of simulations whose files
+	// changed
+	Clean bool
+	// Overwrite existing contents without asking, unless results or
+	// logs would be deleted
+	Yes bool
+	// Overwrite existing contents without asking, even results and logs
+	Force bool
+	// Never overwrite or remove anything that exists, only creating what
+	// is missing
+	NoClobber bool
+}
 
-		if !isEmpty {
-			fmt.Printf("The current directory (\"%s\") has contents other than the .glurmo directory.\n", simDir)
-			fmt.Println("Specifically, it contains the following files or directories:")
-			for _, entry := range contents {
-				fmt.Println(entry)
-			}
-			fmt.Printf("Proceeding with setup may overwrite some or all of these contents. Would you like to proceed anyway? (y/n): ")
-			reader := bufio.NewReader(os.Stdin)
-			nextActionString, err := reader.ReadString('\n')
-			if err != nil {
-				return errorString{fmt.Sprintf("could not read user selection: %s\n", err)}
-			}
+// Given the path to a glurmo directory and a SettingsMap, sets up the
+// infrastructure of the glurmo directory. Unless `options.Incremental`
+// is set, previous setups of its leaves are removed first; if that
+// would overwrite anything, `ConfirmSetup` decides whether to go ahead.
+func SetupDir(simDir string, settingsMap SettingsMap, options SetupOptions) error {
+	axes, err := GetGridAxes(settingsMap)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not parse script settings: %s", err)}
+	}
 
-			if nextActionString != "y\n" {
-				return errorString{"setup was cancelled by user"}
-			}
+	// Everything is staged first, so that an error leaves `simDir`
+	// exactly as it was
+	t, err := NewTransaction(simDir)
+	if err != nil {
+		return err
+	}
+	t.NoClobber = options.NoClobber
+
+	var log bytes.Buffer
+	err = StageSetup(&log, t, simDir, settingsMap, axes, options)
+	if err != nil {
+		fmt.Print(log.String())
+		t.Discard()
+		return err
+	}
+
+	// An incremental setup is expected to rewrite the files whose inputs
+	// changed, so it only asks when it would remove something, e.g. the
+	// leaves and outputs -clean removes
+	if _, removed := t.Clobbered(); !options.Incremental || len(removed) > 0 {
+		err = ConfirmSetup(t, simDir, options)
+		if err != nil {
+			t.Discard()
+			return err
 		}
 	}
 
-	// TODO: get "list" variables
-	listVariables := GetListVars(settingsMap.Templates)
-	if firstVariable, nonEmpty := FirstKey(listVariables); nonEmpty {
-		variableValues, err := UnpackList(listVariables[firstVariable])
+	fmt.Print(log.String())
+	err = t.Commit()
+	if err != nil {
+		return err
+	}
+	if skipped := t.Skipped(); len(skipped) > 0 {
+		fmt.Printf("Left %d existing files and directories unchanged (-no-clobber)\n", len(skipped))
+	}
+	return nil
+}
+
+// Stages the setup of `simDir`, whose list and dict variables are
+// `axes`, in `t`, writing progress to `w`
+func StageSetup(w io.Writer, t *Transaction, simDir string, settingsMap SettingsMap, axes []GridAxis, options SetupOptions) error {
+	if len(axes) == 0 {
+		// No list or dict variables, just set up as single directory
+		changed, err := SetupSims(t, RenderTarget{TemplateDir: simDir, SimDir: simDir, Settings: settingsMap})
 		if err != nil {
-			return errorString{fmt.Sprintf("could not parse script settings: %s", err)}
+			return err
 		}
-		if len(variableValues) == 0 {
-			return errorString{fmt.Sprintf("script variable '%s' contains an empty list", firstVariable)}
+		if options.Incremental {
+			return FinishIncrementalSetup(w, t, simDir, changed, options)
 		}
+		return nil
+	}
 
-		dirsToMake := variableValues
+	// Every combination of list and dict variable values gets its own
+	// leaf directory
+	layout, err := GetLayout(settingsMap.General)
+	if err != nil {
+		return err
+	}
+	points := ExpandGrid(axes)
+	nCombinations := len(points)
 
-		// TODO: cleanup `dirsToMake` on error
-		for i := range dirsToMake {
-			newSettings := DeepCopySettings(settingsMap)
-			newSettings.Templates[firstVariable] = variableValues[i]
-			newSettings.General["id"] += "_" + variableValues[i]
+	pruneRules, err := GetPruneRules(settingsMap, axes)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not parse script settings: %s", err)}
+	}
+	points, err = PruneGrid(points, settingsMap.Templates, pruneRules)
+	if err != nil {
+		return err
+	}
+	err = CheckLeafDirs(points, layout)
+	if err != nil {
+		return err
+	}
+	if len(pruneRules) > 0 {
+		PrintPruneReport(w, nCombinations, len(points), pruneRules)
+	}
+	if len(points) == 0 {
+		return errorString{"every combination of list and dict variables was excluded - nothing to set up"}
+	}
 
-			dirsToMake[i] = filepath.Join(simDir, fmt.Sprintf("%s_%s", firstVariable, variableValues[i]))
-			RemoveIfExists(dirsToMake[i])
-			os.Mkdir(dirsToMake[i], 0700)
-			os.Mkdir(filepath.Join(dirsToMake[i], ".glurmo"), 0700)
+	if options.Incremental || options.NoClobber {
+		err = SetupIncremental(w, t, simDir, settingsMap, points, layout, options)
+		if err != nil {
+			return err
+		}
+		return WriteDesign(t, simDir, points, layout)
+	}
 
-			CopyFile(filepath.Join(simDir, ".glurmo", "script_template"),
-				filepath.Join(dirsToMake[i], ".glurmo", "script_template"))
-			CopyFile(filepath.Join(simDir, ".glurmo", "slurm_template"),
-				filepath.Join(dirsToMake[i], ".glurmo", "slurm_template"))
+	// Leaves of values that were dropped from the grid are removed too,
+	// which `ConfirmSetup` asks about like any other removal
+	expected := make([]string, 0, len(points))
+	for _, point := range points {
+		expected = append(expected, filepath.Join(simDir, point.Dir(layout)))
+	}
+	staleDirs, err := StaleLeafDirs(simDir, expected)
+	if err != nil {
+		return err
+	}
+	for _, leafDir := range staleDirs {
+		fmt.Fprintln(w, "Removing ", leafDir, "(no longer part of the grid) ...")
+		err = RemoveLeaf(t, simDir, leafDir)
+		if err != nil {
+			return err
+		}
+	}
 
-			newSettingsJSON, err := json.MarshalIndent(newSettings, "", "\t")
-			if err != nil {
-				return err
-			}
-			os.WriteFile(filepath.Join(dirsToMake[i], ".glurmo", "settings.json"),
-				newSettingsJSON, 0700)
-
-			fmt.Println("Creating ", dirsToMake[i], "...")
-			err = SetupDir(dirsToMake[i], newSettings, false)
-			if err != nil {
-				// TODO: cleanup directories
-				cleanupErr := RemoveAllSlice(dirsToMake)
-				if cleanupErr != nil {
-					fmt.Printf("WARNING: could not clean up directory %s: %s", simDir, cleanupErr)
-				}
-				return err
-			}
+	for _, point := range points {
+		topDir := filepath.Join(simDir, point.Labels[0])
+		if layout == flatLayout {
+			topDir = filepath.Join(simDir, point.Dir(layout))
 		}
-	} else {
-		// TODO: cleanup dirs on error
-		// No list variables, just set up as single directory
-		err := ScriptSetup(simDir, settingsMap.Templates, settingsMap.General)
+		err = t.Remove(topDir)
 		if err != nil {
 			return err
 		}
-		err = SlurmSetup(simDir, settingsMap.Templates, settingsMap.General)
+	}
+
+	for _, point := range points {
+		leafDir := filepath.Join(simDir, point.Dir(layout))
+		fmt.Fprintln(w, "Creating ", leafDir, "...")
+		_, err = SetupLeaf(t, simDir, leafDir, point.Settings(settingsMap), point.Labels)
 		if err != nil {
 			return err
 		}
 	}
 
-	// TODO: get "dict" variables
-	// TODO: make sub-directories accordingly
-
-	return nil
+	return WriteDesign(t, simDir, points, layout)
 }
 
-// Checks if the given directory is empty (aside from a
-// .glurmo subdirectory)
-func CheckIfEmpty(simDir string) (bool, []string, error) {
-	simDirFiles, err := os.ReadDir(simDir)
+// Sets up the leaf directory `leafDir` of the meta directory `simDir`
+// with settings `leafSettings`, which must not contain list or dict
+// variables.
+// The templates and partials of `simDir` are copied into the leaf,
+// along with the overrides for `levels`, the labels of the leaf.
+// Returns the indices of the simulations whose files changed.
+func SetupLeaf(t *Transaction, simDir string, leafDir string, leafSettings SettingsMap, levels []string) ([]int, error) {
+	err := t.MkdirAll(filepath.Join(leafDir, ".glurmo"))
 	if err != nil {
-		return false, nil, err
+		return nil, err
 	}
 
-	simDirFileStrings := make([]string, 0, len(simDirFiles))
-	for _, dirEntry := range simDirFiles {
-		if dirEntry.Name() != ".glurmo" {
-			simDirFileStrings = append(simDirFileStrings, dirEntry.Name())
-		}
+	err = CopyTemplates(t, simDir, leafDir, levels)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(simDirFileStrings) != 0 {
-		return false, simDirFileStrings, nil
+	err = WriteSettings(t, leafDir, leafSettings)
+	if err != nil {
+		return nil, err
 	}
-	return true, nil, nil
+
+	return SetupSims(t, RenderTarget{TemplateDir: simDir, SimDir: leafDir, Settings: leafSettings, Levels: levels})
+}
+
+// Writes the script and slurm files of every simulation of `target`,
+// returning the indices of the simulations whose files changed
+func SetupSims(t *Transaction, target RenderTarget) ([]int, error) {
+	changedScripts, err := ScriptSetup(t, target)
+	if err != nil {
+		return nil, err
+	}
+	changedSlurm, err := SlurmSetup(t, target)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := append(changedScripts, changedSlurm...)
+	slices.Sort(changed)
+	return slices.Compact(changed), nil
 }
 
-// Sets up the script subdirectory of `simDir` directory
-func ScriptSetup(simDir string, scriptDict map[string]string, generalSettings map[string]string) error {
-	scriptTemplate, err := GetScriptTemplate(simDir)
+// Stages the script subdirectory of `target.SimDir` in `t`. Only
+// scripts whose contents changed are rewritten, and their indices
+// returned; any other files in the subdirectory, such as scripts
+// beyond `n_sims`, are removed.
+func ScriptSetup(t *Transaction, target RenderTarget) ([]int, error) {
+	simDir, scriptDict, generalSettings := target.SimDir, target.Settings.Templates, target.Settings.General
+	scriptTemplate, err := GetScriptTemplate(target.TemplateDir, target.Levels)
 	if err != nil {
-		return errorString{fmt.Sprintf("could not get script template: %s\n", err)}
+		return nil, errorString{fmt.Sprintf("could not get script template: %s\n", err)}
 
 	}
 	scriptTemplate.Option("missingkey=error")
 
-	os.Mkdir(filepath.Join(simDir, "scripts"), 0700)
-	os.Mkdir(filepath.Join(simDir, "results"), 0700)
+	for _, subdir := range []string{"scripts", "results"} {
+		err = t.MkdirAll(filepath.Join(simDir, subdir))
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	nSimsString, hasKey := generalSettings["n_sims"]
+	nSimsValue, hasKey := generalSettings["n_sims"]
 	if !hasKey {
-		return errorString{fmt.Sprintf("\"n_sims\" must be specified in \"general\" section of \".glurmo/settings.json\" (%s)", simDir)}
+		return nil, errorString{fmt.Sprintf("\"n_sims\" must be specified in \"general\" section of the settings file (%s)", simDir)}
 	}
-	nSims, err := strconv.Atoi(nSimsString)
+	nSims, err := AsInt(nSimsValue)
 	if err != nil {
-		return errorString{fmt.Sprintf("could not set up script files: %s", err)}
+		return nil, errorString{fmt.Sprintf("could not set up script files: %s", err)}
 	}
 
+	changed := make([]int, 0)
+	written := make([]string, 0, nSims)
 	for i := 0; i < nSims; i++ {
-		scriptDict["index"] = fmt.Sprint(i)
-		scriptDict["results_path"] = filepath.Join(simDir, "results", "results___"+scriptDict["index"])
-		scriptDict["path_to_script"] = filepath.Join(simDir, "scripts", "script_"+
-			scriptDict["index"]+scriptDict["script_extension"])
-		scriptDict["sim_id"] = generalSettings["id"] + "_" + scriptDict["index"]
-		var finalScriptRaw bytes.Buffer
-
-		err = scriptTemplate.Execute(&finalScriptRaw, scriptDict)
+		scriptData := ScriptTemplateData(simDir, scriptDict, generalSettings, i)
+		currentScriptString, err := RenderTemplate(&scriptTemplate, scriptData)
 		if err != nil {
-			return errorString{fmt.Sprintf("could not populate script template: %s\n", err)}
+			return nil, errorString{fmt.Sprintf("could not populate script template: %s\n", err)}
 		}
 
-		currentScriptString := finalScriptRaw.String()
-
-		f, err := os.Create(scriptDict["path_to_script"])
+		path := GetString(scriptData, "path_to_script")
+		written = append(written, path)
+		wrote, err := WriteIfChanged(t, path, currentScriptString)
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		_, err = f.WriteString(currentScriptString)
-		if err != nil {
-			return err
+		if wrote {
+			changed = append(changed, i)
 		}
-
 	}
 
-	return nil
+	return changed, RemoveOtherFiles(t, filepath.Join(simDir, "scripts"), written)
 }
 
-// Sets up slurm subdirectory of `simDir`
-func SlurmSetup(simDir string, slurmDict map[string]string, generalSettings map[string]string) error {
-	simID, hasKey := generalSettings["id"]
-	if !hasKey {
-		return errorString{fmt.Sprintf("\"id\" must be specified in \"general\" section of \".glurmo/settings.json\" (%s)", simDir)}
+// Stages the slurm subdirectory of `target.SimDir` in `t`. Only slurm
+// files whose contents changed are rewritten, and their indices
+// returned; any other files in the subdirectory are removed.
+func SlurmSetup(t *Transaction, target RenderTarget) ([]int, error) {
+	simDir, slurmDict, generalSettings := target.SimDir, target.Settings.Templates, target.Settings.General
+	if _, hasKey := generalSettings["id"]; !hasKey {
+		return nil, errorString{fmt.Sprintf("\"id\" must be specified in \"general\" section of the settings file (%s)", simDir)}
 	}
 
-	slurmDict["id"] = simID
-
-	slurmTemplate, err := GetSlurmTemplate(simDir)
+	slurmTemplate, err := GetSlurmTemplate(target.TemplateDir, target.Levels)
 	if err != nil {
-		return errorString{fmt.Sprintf("could not get slurm template: %s\n", err)}
+		return nil, errorString{fmt.Sprintf("could not get slurm template: %s\n", err)}
 	}
 
 	slurmTemplate.Option("missingkey=error")
 
-	os.Mkdir(filepath.Join(simDir, "slurm"), 0700)
-	os.Mkdir(filepath.Join(simDir, "slurm_out"), 0700)
-	os.Mkdir(filepath.Join(simDir, "slurm_errors"), 0700)
+	for _, subdir := range []string{"slurm", "slurm_out", "slurm_errors"} {
+		err = t.MkdirAll(filepath.Join(simDir, subdir))
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	nSimsString, hasKey := generalSettings["n_sims"]
+	nSimsValue, hasKey := generalSettings["n_sims"]
 	if !hasKey {
-		return errorString{fmt.Sprintf("\"n_sims\" must be specified in \"general\" section of \".glurmo/settings.json\" (%s)", simDir)}
+		return nil, errorString{fmt.Sprintf("\"n_sims\" must be specified in \"general\" section of the settings file (%s)", simDir)}
 	}
-	nSims, err := strconv.Atoi(nSimsString)
+	nSims, err := AsInt(nSimsValue)
 	if err != nil {
-		return errorString{fmt.Sprintf("could not set up slurm files: %s", err)}
+		return nil, errorString{fmt.Sprintf("could not set up slurm files: %s", err)}
 	}
 
+	changed := make([]int, 0)
+	written := make([]string, 0, nSims)
 	for i := 0; i < nSims; i++ {
-		slurmDict["index"] = fmt.Sprint(i)
-		slurmDict["path_to_slurm_script"] = filepath.Join(simDir, "slurm", "slurm_"+slurmDict["index"])
-		slurmDict["path_to_script"] = filepath.Join(simDir, "scripts", "script_"+
-			slurmDict["index"]+slurmDict["script_extension"])
-		slurmDict["job_id"] = generalSettings["id"] + "___" + slurmDict["index"]
-		slurmDict["output_path"] = filepath.Join(simDir, "slurm_out", "output___"+slurmDict["index"])
-		slurmDict["error_path"] = filepath.Join(simDir, "slurm_errors", "error___"+slurmDict["index"])
-
-		var slurmRaw bytes.Buffer
-
-		err = slurmTemplate.Execute(&slurmRaw, slurmDict)
+		slurmData := SlurmTemplateData(simDir, slurmDict, generalSettings, i)
+		slurmString, err := RenderTemplate(&slurmTemplate, slurmData)
 		if err != nil {
-			return errorString{fmt.Sprintf("could not populate slurm template: %s\n", err)}
+			return nil, errorString{fmt.Sprintf("could not populate slurm template: %s\n", err)}
 		}
 
-		slurmString := slurmRaw.String()
-
-		f, err := os.Create(slurmDict["path_to_slurm_script"])
+		path := GetString(slurmData, "path_to_slurm_script")
+		written = append(written, path)
+		wrote, err := WriteIfChanged(t, path, slurmString)
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		_, err = f.WriteString(slurmString)
-		if err != nil {
-			return err
+		if wrote {
+			changed = append(changed, i)
 		}
-
 	}
 
-	return nil
+	return changed, RemoveOtherFiles(t, filepath.Join(simDir, "slurm"), written)
 }
 
-// Cleans up glurmo directory in case of an error
-// TODO: clean up other directories as well
-func CleanupOnErr(simDir string) error {
-	err := os.RemoveAll(filepath.Join(simDir, "scripts"))
-	if err != nil {
-		return err
-	}
-	err = os.RemoveAll(filepath.Join(simDir, "slurm"))
+// Returns the data the script template of simulation directory
+// `simDir` is executed with for simulation `index`: the template
+// settings, plus the keys glurmo adds for each simulation
+func ScriptTemplateData(simDir string, templateSettings map[string]any, generalSettings map[string]any, index int) map[string]any {
+	data := maps.Clone(templateSettings)
+	indexString := fmt.Sprint(index)
+
+	data["index"] = indexString
+	data["results_path"] = filepath.Join(simDir, "results", "results___"+indexString)
+	data["path_to_script"] = filepath.Join(simDir, "scripts", "script_"+
+		indexString+GetString(templateSettings, "script_extension"))
+	data["sim_id"] = GetString(generalSettings, "id") + "_" + indexString
+
+	return data
+}
+
+// Returns the data the slurm template of simulation directory
+// `simDir` is executed with for simulation `index`. This includes
+// everything the script template gets.
+func SlurmTemplateData(simDir string, templateSettings map[string]any, generalSettings map[string]any, index int) map[string]any {
+	data := ScriptTemplateData(simDir, templateSettings, generalSettings, index)
+	indexString := fmt.Sprint(index)
+
+	data["id"] = GetString(generalSettings, "id")
+	data["path_to_slurm_script"] = filepath.Join(simDir, "slurm", "slurm_"+indexString)
+	data["job_id"] = GetString(generalSettings, "id") + "___" + indexString
+	data["output_path"] = filepath.Join(simDir, "slurm_out", "output___"+indexString)
+	data["error_path"] = filepath.Join(simDir, "slurm_errors", "error___"+indexString)
+
+	return data
+}
+
+// Executes `tmpl` with `data`, and returns the result
+func RenderTemplate(tmpl *template.Template, data map[string]any) (string, error) {
+	var rendered bytes.Buffer
+	err := tmpl.Execute(&rendered, data)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return rendered.String(), nil
 }
 
 // Determines which variables in the simulation settings are
-// list variables, i.e. will create their own glurmo subdirectories
-// recursively.
-func GetListVars(settings map[string]string) map[string]string {
-	listVars := make(map[string]string)
+// list variables, i.e. will create their own glurmo subdirectories.
+func GetListVars(settings map[string]any) map[string]any {
+	listVars := make(map[string]any)
 	for k, v := range settings {
-		if strings.HasPrefix(v, "@") {
+		if IsListVar(v) {
 			listVars[k] = v
 		}
 	}
 	return listVars
 }
 
+// Checks whether a setting is a list variable: either an array, or a
+// string starting with `@`
+func IsListVar(v any) bool {
+	switch value := v.(type) {
+	case []any:
+		return true
+	case string:
+		return strings.HasPrefix(value, "@")
+	}
+	return false
+}
+
+// Returns the values of the list variable `list`, which is either an
+// array of strings, numbers and booleans, or a string in one of the
+// formats understood by `UnpackList`, whose numeric elements become
+// numbers just like in an array. The zero padded values of `@seq` are
+// all kept as strings.
+func ListValues(list any) ([]any, error) {
+	switch value := list.(type) {
+	case []any:
+		for _, element := range value {
+			if !IsScalar(element) {
+				return nil, errorString{fmt.Sprintf("list values must be strings, numbers or booleans, got %s", FormatValue(element))}
+			}
+		}
+		return value, nil
+	case string:
+		unpacked, err := UnpackList(value)
+		if err != nil {
+			return nil, err
+		}
+		padded := strings.HasPrefix(strings.TrimSpace(value), "@seq(")
+		values := make([]any, 0, len(unpacked))
+		for _, element := range unpacked {
+			if padded {
+				values = append(values, element)
+			} else {
+				values = append(values, ParseListElement(element))
+			}
+		}
+		return values, nil
+	}
+	return nil, errorString{fmt.Sprintf("%s is not a list", FormatValue(list))}
+}
+
 // Given a variable list of the form `@[v_1, ..., v_n]`,
-// returns a slice [v_1, v_n]
+// returns a slice [v_1, v_n]. Lists may also be given by a
+// generator such as `@range(1, 50)`; see `UnpackGenerator`.
 func UnpackList(s string) ([]string, error) {
-	if s[0:2] != "@[" || s[len(s)-1] != ']' {
-		return nil, errorString{"malformed list - lists must be enclosed by @[ ... ]"}
+	if generatorRegexp.MatchString(s) {
+		return UnpackGenerator(s)
+	}
+	if !strings.HasPrefix(s, "@[") || !strings.HasSuffix(s, "]") {
+		return nil, errorString{"malformed list - lists must be enclosed by @[ ... ] or be a generator such as @range(1, 10)"}
+	}
+	contents := strings.TrimSpace(s[2 : len(s)-1])
+	if contents == "" {
+		return []string{}, nil
+	}
+	splitList := strings.Split(contents, ",")
+	for i := range splitList {
+		splitList[i] = strings.TrimSpace(splitList[i])
 	}
-	re := regexp.MustCompile(", *")
-	splitList := re.Split(s[2:len(s)-1], -1)
 	return splitList, nil
 }
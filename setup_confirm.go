@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Subdirectories of a simulation directory holding what simulations
+// produced, or what was written when they were submitted, as opposed
+// to files glurmo generates
+var outputSubdirs = []string{"results", "slurm_out", "slurm_errors", "condor"}
+
+// The number of paths listed for each kind of change before the rest
+// are summarized
+const maxListedChanges = 10
+
+// What committing a setup would do to existing contents of a
+// simulation directory. Paths are relative to the simulation directory.
+type SetupChanges struct {
+	// Generated files that would be overwritten or removed
+	Generated []string
+	// Results and logs that would be removed
+	Outputs []string
+	// Top level entries that would be left alone
+	Preserved []string
+}
+
+// Decides whether the setup staged in `t` may go ahead. Nothing needs
+// confirming if no existing contents would be overwritten or removed.
+// Otherwise, `options.Force` always goes ahead and `options.Yes` goes
+// ahead unless results or logs would be removed. Without either, the
+// user is asked if stdin is a terminal; if it is not, setup fails
+// rather than waiting for an answer.
+func ConfirmSetup(t *Transaction, simDir string, options SetupOptions) error {
+	changes, err := GetSetupChanges(t, simDir)
+	if err != nil {
+		return errorString{fmt.Sprintf("could not check what setup would overwrite: %s", err)}
+	}
+	if len(changes.Generated) == 0 && len(changes.Outputs) == 0 {
+		return nil
+	}
+	if options.Force {
+		return nil
+	}
+	if options.Yes {
+		if len(changes.Outputs) > 0 {
+			PrintSetupChanges(os.Stderr, simDir, changes)
+			keep := "-incremental"
+			if options.Incremental {
+				keep = "no -clean"
+			}
+			return errorString{fmt.Sprintf("setup would delete %d results and logs - use -force to delete them, or %s to keep them",
+				len(changes.Outputs), keep)}
+		}
+		return nil
+	}
+
+	if !IsTerminal(os.Stdin) {
+		PrintSetupChanges(os.Stderr, simDir, changes)
+		return errorString{fmt.Sprintf("%s has existing contents that setup would overwrite - rerun with -yes, -force, -no-clobber or -incremental",
+			simDir)}
+	}
+
+	PrintSetupChanges(os.Stdout, simDir, changes)
+	fmt.Printf("Would you like to proceed? (y/n): ")
+	reader := bufio.NewReader(os.Stdin)
+	nextActionString, err := reader.ReadString('\n')
+	if err != nil {
+		return errorString{fmt.Sprintf("could not read user selection: %s\n", err)}
+	}
+
+	answer := strings.ToLower(strings.TrimSpace(nextActionString))
+	if answer != "y" && answer != "yes" {
+		return errorString{"setup was cancelled by user"}
+	}
+	return nil
+}
+
+// Sorts the existing contents of `simDir` that the setup staged in `t`
+// would overwrite or remove into generated files and outputs, and
+// finds the top level entries it would not touch
+func GetSetupChanges(t *Transaction, simDir string) (SetupChanges, error) {
+	changes := SetupChanges{
+		Generated: make([]string, 0),
+		Outputs:   make([]string, 0),
+		Preserved: make([]string, 0),
+	}
+	addFile := func(path string) {
+		rel, _ := filepath.Rel(simDir, path)
+		if IsOutputPath(rel) {
+			changes.Outputs = append(changes.Outputs, rel)
+		} else {
+			changes.Generated = append(changes.Generated, rel)
+		}
+	}
+
+	overwritten, removed := t.Clobbered()
+	for _, path := range overwritten {
+		addFile(path)
+	}
+	for _, path := range removed {
+		err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() {
+				addFile(file)
+			}
+			return nil
+		})
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	entries, err := os.ReadDir(simDir)
+	if err != nil {
+		return changes, err
+	}
+	touched := append(overwritten, removed...)
+	for _, entry := range entries {
+		if entry.Name() == ".glurmo" {
+			continue
+		}
+		path := filepath.Join(simDir, entry.Name())
+		isTouched := slices.ContainsFunc(touched, func(other string) bool {
+			return other == path || strings.HasPrefix(other, path+string(filepath.Separator))
+		})
+		if !isTouched {
+			changes.Preserved = append(changes.Preserved, entry.Name())
+		}
+	}
+
+	slices.Sort(changes.Generated)
+	slices.Sort(changes.Outputs)
+	return changes, nil
+}
+
+// Checks whether `rel`, relative to a meta or simulation directory, is
+// inside a results, slurm_out, slurm_errors or condor subdirectory
+func IsOutputPath(rel string) bool {
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if slices.Contains(outputSubdirs, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prints what committing a setup of `simDir` would do to its existing
+// contents
+func PrintSetupChanges(w io.Writer, simDir string, changes SetupChanges) {
+	fmt.Fprintf(w, "Setting up %s would change its existing contents.\n", simDir)
+	if len(changes.Outputs) > 0 {
+		fmt.Fprintf(w, "It would DELETE %d results and logs:\n", len(changes.Outputs))
+		PrintPathList(w, changes.Outputs)
+	}
+	if len(changes.Generated) > 0 {
+		fmt.Fprintf(w, "It would overwrite or remove %d generated files (scripts, slurm files, settings and templates):\n",
+			len(changes.Generated))
+		PrintPathList(w, changes.Generated)
+	}
+	if len(changes.Preserved) > 0 {
+		fmt.Fprintln(w, "It would leave alone:")
+		PrintPathList(w, changes.Preserved)
+	}
+}
+
+// Prints up to `maxListedChanges` of `paths`, one per line, followed by
+// how many more there are
+func PrintPathList(w io.Writer, paths []string) {
+	for _, path := range paths[:min(len(paths), maxListedChanges)] {
+		fmt.Fprintf(w, "  %s\n", path)
+	}
+	if len(paths) > maxListedChanges {
+		fmt.Fprintf(w, "  ... and %d more\n", len(paths)-maxListedChanges)
+	}
+}
+
+// Checks whether `file` is a terminal, as opposed to a pipe, a regular
+// file or /dev/null
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is a character device too
+	devNull, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, devNull)
+}
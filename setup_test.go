@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIncrementalCleanSetupConfirms(t *testing.T) {
+	simDir := setupTestGrid(t)
+	writeTestResult(t, filepath.Join(simDir, "n_1"), 0)
+	resultPath := filepath.Join(simDir, "n_1", "results", "results___0")
+	// Every script changes, so -clean removes every result
+	err := os.WriteFile(filepath.Join(simDir, ".glurmo", "script_template"), []byte("echo {{.n}} changed > {{.results_path}}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settingsMap, err := GetSettings(simDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tests do not run with a terminal as stdin, so setup cannot ask
+	for _, options := range []SetupOptions{
+		{Incremental: true, Clean: true},
+		{Incremental: true, Clean: true, Yes: true},
+	} {
+		if err := SetupDir(simDir, settingsMap, options); err == nil {
+			t.Errorf("setup with %+v deleted results without asking", options)
+		}
+		if _, err := os.Stat(resultPath); err != nil {
+			t.Errorf("setup with %+v removed the result: %s", options, err)
+		}
+	}
+
+	if err := SetupDir(simDir, settingsMap, SetupOptions{Incremental: true, Clean: true, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(resultPath); err == nil {
+		t.Errorf("setup with -force kept the result of a changed simulation")
+	}
+}
@@ -1,87 +1,135 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"os/exec"
-	"strings"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
 )
 
-func CheckSimStatus(username string) error {
-	cur_running, err := GetAllCurrentJobs()
-	if err != nil {
-		return err
-	}
-
-	_, job_states := GetJobNamesAndStates(&cur_running, username)
+// Status of the simulations in a single glurmo directory. Every
+// index in [0, `NSims`) is counted exactly once: as completed if it
+// has a result, otherwise as pending or running if it is in the
+// queue, and as not submitted if it is neither.
+type SimStatus struct {
+	Dir          string
+	NSims        int
+	Completed    int
+	Running      int
+	Pending      int
+	NotSubmitted int
+}
 
-	state_counts := make(map[string]int)
+// Adds the counts of `other` to `s`
+func (s *SimStatus) Add(other SimStatus) {
+	s.NSims += other.NSims
+	s.Completed += other.Completed
+	s.Running += other.Running
+	s.Pending += other.Pending
+	s.NotSubmitted += other.NotSubmitted
+}
 
-	state_counts["RUNNING"] = 0
-	state_counts["PENDING"] = 0
+// Returns the status of every simulation directory at or below
+// `simDir`, which may be a meta directory
+func CheckSimStatus(sched Scheduler, simDir string) ([]SimStatus, error) {
+	leafDirs, err := GetLeafDirs(simDir)
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("could not get simulation status: %s", err)}
+	}
+	if len(leafDirs) == 0 {
+		return nil, errorString{fmt.Sprintf("no simulation directories found in %s - has it been set up?", simDir)}
+	}
 
-	for _, state := range job_states {
-		state_counts[state] = state_counts[state] + 1
+	allJobs, err := sched.List()
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("could not retrieve queued jobs: %s", err)}
 	}
 
-	fmt.Println()
-	for state, count := range state_counts {
-		plural := "s"
-		if count == 1 {
-			plural = ""
+	statuses := make([]SimStatus, 0, len(leafDirs))
+	for _, leafDir := range leafDirs {
+		status, err := GetLeafStatus(leafDir, allJobs)
+		if err != nil {
+			return nil, err
 		}
-		fmt.Printf("%d job"+plural+" in state %s\n", count, state)
+		statuses = append(statuses, status)
 	}
-	fmt.Println()
 
-	return nil
+	return statuses, nil
 }
 
-func GetAllCurrentJobs() ([][]string, error) {
-	cur_running_bytes, err := exec.Command("squeue").Output()
+// Returns the status of the simulation directory `simDir`, given all
+// jobs currently queued
+func GetLeafStatus(simDir string, allJobs []Job) (SimStatus, error) {
+	status := SimStatus{Dir: simDir}
+
+	settingsMap, err := GetSettings(simDir)
 	if err != nil {
-		return nil, err
+		return status, errorString{fmt.Sprintf("could not get status of %s: %s", simDir, err)}
+	}
+	status.NSims, err = settingsMap.NSims()
+	if err != nil {
+		return status, errorString{fmt.Sprintf("could not get status of %s: %s", simDir, err)}
 	}
 
-	cur_running_string := strings.TrimSpace(string(cur_running_bytes))
-
-	cur_running := make([][]string, 0, strings.Count(cur_running_string, "\n")+1)
-
-	scanner := bufio.NewScanner(strings.NewReader(cur_running_string))
+	_, completedMap, err := GetNumberCompleted(simDir, GetString(settingsMap.Templates, "result_extension"))
+	if err != nil {
+		return status, err
+	}
 
-	scanner.Split(bufio.ScanLines)
+	stateMap := make(map[int]string)
+	for _, job := range FilterSimJobs(allJobs, settingsMap.ID()) {
+		jobNum, err := GetJobNumber(job.JobName)
+		if err != nil {
+			return status, errorString{fmt.Sprintf("could not get status of %s: %s", simDir, err)}
+		}
+		stateMap[jobNum] = job.State
+	}
 
-	for scanner.Scan() {
-		// split line
-		cur_running = append(cur_running, strings.Fields(scanner.Text()))
+	for i := 0; i < status.NSims; i++ {
+		state, submitted := stateMap[i]
+		switch {
+		case completedMap[i]:
+			status.Completed++
+		case !submitted:
+			status.NotSubmitted++
+		case state == JobPending:
+			status.Pending++
+		default:
+			status.Running++
+		}
 	}
 
-	return cur_running, nil
+	return status, nil
 }
 
-func GetJobNamesAndStates(all_jobs *[][]string, username string) (job_names []string, job_states []string) {
-	username_column := IndexOf((*all_jobs)[0], "USER")
-	job_name_column := IndexOf((*all_jobs)[0], "NAME")
-	job_state_column := IndexOf((*all_jobs)[0], "STATE")
-
-	job_names = make([]string, 0, len(*all_jobs))
-	job_states = make([]string, 0, len(*all_jobs))
-
-	for _, row := range *all_jobs {
-		if row[username_column] == username {
-			job_names = append(job_names, row[job_name_column])
-			job_states = append(job_states, row[job_state_column])
+// Prints a table of `statuses` to `w`, with directories shown relative
+// to `simDir`, followed by a total over all of them
+func PrintSimStatus(w io.Writer, simDir string, statuses []SimStatus) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "simulation\tn_sims\tcompleted\trunning\tpending\tnot submitted")
+
+	var total SimStatus
+	for _, status := range statuses {
+		relDir, err := filepath.Rel(simDir, status.Dir)
+		if err != nil {
+			relDir = status.Dir
 		}
+		PrintStatusRow(tw, relDir, status)
+		total.Add(status)
 	}
-	return job_names, job_states
-}
+	if len(statuses) > 1 {
+		PrintStatusRow(tw, "total", total)
+	}
+	tw.Flush()
 
-func IndexOf(list []string, target string) int {
-	for i, s := range list {
-		if s == target {
-			return i
-		}
+	if total.NSims > 0 {
+		fmt.Fprintf(w, "\n%d of %d simulations completed (%.1f%%)\n", total.Completed, total.NSims,
+			100*float64(total.Completed)/float64(total.NSims))
 	}
+}
 
-	return -1
+// Prints a single row of the status table
+func PrintStatusRow(w io.Writer, name string, status SimStatus) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n", name, status.NSims, status.Completed,
+		status.Running, status.Pending, status.NotSubmitted)
 }
@@ -2,45 +2,116 @@ package main
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// Simple struct representing a submitted slurm job.
-// `ID“ is the job id, `JobName` is the job name, and
-// `State` is the current state of the job
-type SlurmJob struct {
-	ID      string
-	JobName string
-	State   string
+// The slurm workload manager, driven through its command line tools
+type SlurmScheduler struct{}
+
+// Submits the slurm file of `spec` with sbatch
+func (SlurmScheduler) Submit(spec JobSpec) (string, error) {
+	res, err := CommandString("sbatch", spec.SlurmPath())
+	if err != nil {
+		return "", err
+	}
+	jobID, submitted := strings.CutPrefix(strings.TrimSpace(res), "Submitted batch job ")
+	if !submitted {
+		return "", errorString{fmt.Sprintf("unexpected output from sbatch: %s", res)}
+	}
+	return jobID, nil
 }
 
-// For simulation `simName`, returns a list of `SlurmJobs`, representing
-// all jobs from this simulation that have currently been submitted
-func GetCurrentSubmitted(simName string) ([]SlurmJob, error) {
-	raw, err := CommandString("squeue", "--format=\"%.18i %.50j %.8T\"", "--me")
+// The arguments `SlurmScheduler.List` runs squeue with: the id, name and state of
+// every job of the current user, separated by spaces
+var squeueListArgs = []string{"--noheader", "--format=%i %j %T", "--me"}
+
+// Returns the arguments `SlurmScheduler.History` runs sacct with: the id, name and
+// state, separated by |, of every job that finished since `since`
+func SacctHistoryArgs(since time.Time) []string {
+	return []string{"--noheader", "--allocations", "--parsable2",
+		"--format=JobID,JobName,State", "--state=CD,F,CA,TO,OOM,NF,PR",
+		"--starttime", since.Format("2006-01-02T15:04:05")}
+}
+
+// Returns the jobs of the current user that are in the slurm queue
+func (SlurmScheduler) List() ([]Job, error) {
+	raw, err := CommandString("squeue", squeueListArgs...)
 	if err != nil {
 		return nil, err
 	}
+	return ParseJobLines(raw, " "), nil
+}
 
-	lines := strings.Split(raw, "\n")
-	slurmJobs := make([]SlurmJob, 0, len(lines))
+// Cancels `job` with scancel
+func (SlurmScheduler) Cancel(job Job) error {
+	_, err := CommandString("scancel", job.ID)
+	return err
+}
 
-	for i, jobString := range lines {
-		if i == 0 || len(jobString) < 1 {
+// Returns the jobs of the current user that slurm accounting has
+// recorded as finished since `since`
+func (SlurmScheduler) History(since time.Time) ([]Job, error) {
+	raw, err := CommandString("sacct", SacctHistoryArgs(since)...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJobLines(raw, "|"), nil
+}
+
+// Parses `raw`, with a job per line made up of its id, name and state
+// separated by `sep`. Lines with fewer fields are skipped, and only the
+// first word of the state is kept, since sacct reports e.g.
+// `CANCELLED by 1234`.
+func ParseJobLines(raw string, sep string) []Job {
+	lines := strings.Split(raw, "\n")
+	jobs := make([]Job, 0, len(lines))
+
+	for _, line := range lines {
+		var fields []string
+		if sep == " " {
+			fields = strings.Fields(line)
+		} else {
+			fields = strings.Split(strings.TrimSpace(line), sep)
+		}
+		if len(fields) < 3 || fields[2] == "" {
 			continue
 		}
+		jobs = append(jobs, Job{fields[0], fields[1], strings.Fields(fields[2])[0]})
+	}
 
-		jobString = jobString[1 : len(jobString)-1]
+	return jobs
+}
+
+// For simulation `simName`, returns a list of `Jobs`, representing
+// all jobs from this simulation that `sched` currently has queued
+func GetCurrentSubmitted(sched Scheduler, simName string) ([]Job, error) {
+	allJobs, err := sched.List()
+	if err != nil {
+		return nil, err
+	}
 
-		splitJob := strings.Fields(jobString)
-		curJob := SlurmJob{splitJob[0], splitJob[1], splitJob[2]}
-		if strings.HasPrefix(curJob.JobName, simName) {
-			slurmJobs = append(slurmJobs, curJob)
+	return FilterSimJobs(allJobs, simName), nil
+}
+
+// Returns the jobs in `jobs` that belong to simulation `simName`
+func FilterSimJobs(jobs []Job, simName string) []Job {
+	simJobs := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if JobBelongsToSim(job.JobName, simName) {
+			simJobs = append(simJobs, job)
 		}
 	}
+	return simJobs
+}
 
-	return slurmJobs, nil
+// Checks whether a job name of the format [simulation name]___[job number]
+// belongs to simulation `simName`. A plain prefix check is not enough,
+// since e.g. `sim_10___3` starts with `sim_1`.
+func JobBelongsToSim(jobName string, simName string) bool {
+	return strings.HasPrefix(jobName, simName+"___")
 }
 
 // Given a job name in the format [simulation name]___[job number],
@@ -78,7 +149,7 @@ func GetFileNumber(fname string) (int, error) {
 		'9': true}
 
 	takeUntil := 0
-	for takeUntil <= len(suffix) && numericRunes[suffix[takeUntil]] {
+	for takeUntil < len(suffix) && numericRunes[suffix[takeUntil]] {
 		takeUntil++
 	}
 
@@ -90,24 +161,62 @@ func GetFileNumber(fname string) (int, error) {
 	return fileNumber, nil
 }
 
-// Given the name of a simulation, retrieves the number submitted
-// (returned as an int) and a map[int]bool that indicates
-// which numbers have been submitted and which have not
-func GetNumberSubmitted(simName string) (int, map[int]bool, error) {
-	currentSubmitted, err := GetCurrentSubmitted(simName)
-	submittedMap := make(map[int]bool, len(currentSubmitted))
-	if err != nil {
-		return 0, nil, errorString{fmt.Sprintf("could not retrieve current slurm jobs: %s", err.Error())}
+var slurmTimeRegexp = regexp.MustCompile(`^(?:(\d+)-)?(\d+)(?::(\d+))?(?::(\d+))?$`)
+
+// Parses a slurm time limit: `M`, `M:S`, `H:M:S`, `D-H`, `D-H:M` or
+// `D-H:M:S`. An empty limit, or "UNLIMITED", is no limit and is
+// returned as 0.
+func ParseSlurmTime(limit string) (time.Duration, error) {
+	limit = strings.TrimSpace(limit)
+	if limit == "" || strings.EqualFold(limit, "UNLIMITED") || strings.EqualFold(limit, "INFINITE") {
+		return 0, nil
+	}
+	match := slurmTimeRegexp.FindStringSubmatch(limit)
+	if match == nil {
+		return 0, errorString{fmt.Sprintf("invalid time limit: %s", limit)}
+	}
+	parts := make([]int64, 0, 3)
+	for _, part := range match[2:] {
+		if part != "" {
+			n, _ := strconv.ParseInt(part, 10, 64)
+			parts = append(parts, n)
+		}
 	}
 
-	for _, job := range currentSubmitted {
-		if strings.HasPrefix(job.JobName, simName) {
-			curJobNum, err := GetJobNumber(job.JobName)
-			if err != nil {
-				return -1, nil, errorString{fmt.Sprintf("could not retrieve current slurm jobs: %s", err.Error())}
-			}
-			submittedMap[curJobNum] = true
+	var days, hours, minutes, seconds int64
+	if match[1] != "" {
+		days, _ = strconv.ParseInt(match[1], 10, 64)
+		hours = parts[0]
+		if len(parts) > 1 {
+			minutes = parts[1]
+		}
+		if len(parts) > 2 {
+			seconds = parts[2]
 		}
+	} else {
+		switch len(parts) {
+		case 1:
+			minutes = parts[0]
+		case 2:
+			minutes, seconds = parts[0], parts[1]
+		case 3:
+			hours, minutes, seconds = parts[0], parts[1], parts[2]
+		}
+	}
+	return time.Duration(((days*24+hours)*60+minutes)*60+seconds) * time.Second, nil
+}
+
+// Formats `d` the way slurm does: "M:SS", "H:MM:SS" or "D-HH:MM:SS",
+// or always "HH:MM:SS" if `fixed` is set, as sacct does
+func FormatSlurmDuration(d time.Duration, fixed bool) string {
+	total := int(d.Seconds())
+	days, hours, minutes, seconds := total/86400, total/3600%24, total/60%60, total%60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%d-%02d:%02d:%02d", days, hours, minutes, seconds)
+	case fixed || hours > 0:
+		return fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
+	default:
+		return fmt.Sprintf("%d:%02d", minutes, seconds)
 	}
-	return len(submittedMap), submittedMap, nil
 }
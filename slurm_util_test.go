@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetFileNumber(t *testing.T) {
+	cases := []struct {
+		fname string
+		want  int
+	}{
+		{"results___3.txt", 3},
+		{"results___12.csv", 12},
+		{"results___7", 7},
+		{"results___0", 0},
+		{"output___45", 45},
+	}
+	for _, c := range cases {
+		got, err := GetFileNumber(c.fname)
+		if err != nil {
+			t.Errorf("GetFileNumber(%q) returned error: %s", c.fname, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("GetFileNumber(%q) = %d, want %d", c.fname, got, c.want)
+		}
+	}
+}
+
+func TestGetFileNumberMalformed(t *testing.T) {
+	for _, fname := range []string{"results", "results___", "results___x.txt", "a___1___2"} {
+		if _, err := GetFileNumber(fname); err == nil {
+			t.Errorf("GetFileNumber(%q) should have returned an error", fname)
+		}
+	}
+}
+
+func TestParseSlurmTime(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":           0,
+		"UNLIMITED":  0,
+		"30":         30 * time.Minute,
+		"5:30":       5*time.Minute + 30*time.Second,
+		"1:02:03":    time.Hour + 2*time.Minute + 3*time.Second,
+		"2-0":        48 * time.Hour,
+		"1-12:30":    36*time.Hour + 30*time.Minute,
+		"1-00:00:05": 24*time.Hour + 5*time.Second,
+	}
+	for limit, want := range cases {
+		got, err := ParseSlurmTime(limit)
+		if err != nil || got != want {
+			t.Errorf("ParseSlurmTime(%q) = %v, %v, want %v", limit, got, err, want)
+		}
+	}
+	for _, limit := range []string{"1:2:3:4", "a", "1-", "-5", "1:"} {
+		if _, err := ParseSlurmTime(limit); err == nil {
+			t.Errorf("ParseSlurmTime(%q) did not fail", limit)
+		}
+	}
+}
+
+func TestFormatSlurmDuration(t *testing.T) {
+	cases := []struct {
+		d     time.Duration
+		fixed bool
+		want  string
+	}{
+		{65 * time.Second, false, "1:05"},
+		{65 * time.Second, true, "00:01:05"},
+		{time.Hour + 5*time.Second, false, "01:00:05"},
+		{26 * time.Hour, false, "1-02:00:00"},
+		{26 * time.Hour, true, "1-02:00:00"},
+	}
+	for _, c := range cases {
+		if got := FormatSlurmDuration(c.d, c.fixed); got != c.want {
+			t.Errorf("FormatSlurmDuration(%v, %t) = %q, want %q", c.d, c.fixed, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Returns the functions available to both the script and slurm
+// templates, in addition to the text/template builtins such as
+// `printf`, `len`, `index` and `eq`:
+//
+//	atoi v                       number or numeric string to integer
+//	add, sub, mul a b ...        arithmetic on numbers or numeric strings
+//	div a b, mod a b             division (integer if both are integers)
+//	upper s, lower s, trim s     string case and whitespace
+//	replace old new s            replaces every `old` in `s` with `new`
+//	padLeft width pad s          pads `s` on the left to `width` characters
+//	duration m                   minutes or a slurm time to `[D-]HH:MM:SS`
+//	minutes t                    a slurm time such as `1-12:00:00` to minutes
+//	mulMemory factor mem         scales a slurm memory size, e.g. `4G` to `8G`
+//	memoryMB mem                 a slurm memory size in megabytes
+//	default fallback v           `fallback` if `v` is missing or empty
+//	json v                       `v` encoded as JSON
+//	seq [start] end [step]       integers from start (default 1) to end
+//
+// Since settings may be missing from some simulations, `default` is
+// usually combined with `index`, which does not fail on missing keys:
+// `{{index . "time" | default 60 | duration}}`.
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"atoi":      AsInt,
+		"add":       func(a any, b ...any) (any, error) { return Arithmetic("add", a, b) },
+		"sub":       func(a any, b ...any) (any, error) { return Arithmetic("sub", a, b) },
+		"mul":       func(a any, b ...any) (any, error) { return Arithmetic("mul", a, b) },
+		"div":       func(a, b any) (any, error) { return Arithmetic("div", a, []any{b}) },
+		"mod":       func(a, b any) (any, error) { return Arithmetic("mod", a, []any{b}) },
+		"upper":     func(v any) string { return strings.ToUpper(FormatValue(v)) },
+		"lower":     func(v any) string { return strings.ToLower(FormatValue(v)) },
+		"trim":      func(v any) string { return strings.TrimSpace(FormatValue(v)) },
+		"replace":   ReplaceValue,
+		"padLeft":   PadLeft,
+		"duration":  FormatDuration,
+		"minutes":   ParseMinutes,
+		"mulMemory": MultiplyMemory,
+		"memoryMB":  MemoryMB,
+		"default":   DefaultValue,
+		"json":      JSONValue,
+		"seq":       Sequence,
+	}
+}
+
+// Converts a template argument to an `int64` or `float64`. Strings
+// are parsed, since some injected settings such as `index` are strings.
+func AsNumber(v any) (any, error) {
+	switch value := v.(type) {
+	case int:
+		return int64(value), nil
+	case int64, float64:
+		return value, nil
+	case string:
+		trimmed := strings.TrimSpace(value)
+		if i, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(trimmed, 64); err == nil {
+			return f, nil
+		}
+	}
+	return nil, errorString{fmt.Sprintf("%s is not a number", FormatValue(v))}
+}
+
+// Converts a template argument to a `float64`
+func AsFloat(v any) (float64, error) {
+	n, err := AsNumber(v)
+	if err != nil {
+		return 0, err
+	}
+	if i, ok := n.(int64); ok {
+		return float64(i), nil
+	}
+	return n.(float64), nil
+}
+
+// Applies arithmetic operation `op` to `first` and each of `rest` in
+// turn. The result is an integer if every operand is an integer, and a
+// float otherwise.
+func Arithmetic(op string, first any, rest []any) (any, error) {
+	result, err := AsNumber(first)
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("%s: %s", op, err)}
+	}
+	for _, arg := range rest {
+		operand, err := AsNumber(arg)
+		if err != nil {
+			return nil, errorString{fmt.Sprintf("%s: %s", op, err)}
+		}
+		result, err = applyArithmetic(op, result, operand)
+		if err != nil {
+			return nil, errorString{fmt.Sprintf("%s: %s", op, err)}
+		}
+	}
+	return result, nil
+}
+
+func applyArithmetic(op string, a, b any) (any, error) {
+	ai, aIsInt := a.(int64)
+	bi, bIsInt := b.(int64)
+	if aIsInt && bIsInt {
+		switch op {
+		case "add":
+			return ai + bi, nil
+		case "sub":
+			return ai - bi, nil
+		case "mul":
+			return ai * bi, nil
+		}
+		if bi == 0 {
+			return nil, errorString{"division by zero"}
+		}
+		if op == "div" {
+			return ai / bi, nil
+		}
+		return ai % bi, nil
+	}
+
+	af, _ := AsFloat(a)
+	bf, _ := AsFloat(b)
+	switch op {
+	case "add":
+		return af + bf, nil
+	case "sub":
+		return af - bf, nil
+	case "mul":
+		return af * bf, nil
+	}
+	if bf == 0 {
+		return nil, errorString{"division by zero"}
+	}
+	if op == "div" {
+		return af / bf, nil
+	}
+	return math.Mod(af, bf), nil
+}
+
+// Replaces every occurrence of `old` in `v` with `new`
+func ReplaceValue(old, new string, v any) string {
+	return strings.ReplaceAll(FormatValue(v), old, new)
+}
+
+// Pads `v` on the left with repetitions of `pad` until it is at least
+// `width` characters long, e.g. `{{.index | padLeft 4 "0"}}`
+func PadLeft(width any, pad string, v any) (string, error) {
+	n, err := AsInt(width)
+	if err != nil {
+		return "", errorString{fmt.Sprintf("padLeft: width %s", err)}
+	}
+	if pad == "" {
+		return "", errorString{"padLeft: pad string is empty"}
+	}
+
+	s := FormatValue(v)
+	for len(s) < n {
+		s = pad + s
+	}
+	return s, nil
+}
+
+// Parses a slurm time limit into minutes. Accepts every format slurm
+// does: `M`, `M:S`, `H:M:S`, `D-H`, `D-H:M` and `D-H:M:S`. Numbers are
+// taken to be minutes already.
+func ParseMinutes(v any) (any, error) {
+	s, isString := v.(string)
+	if !isString || !strings.ContainsAny(s, ":-") {
+		minutes, err := AsNumber(v)
+		if err != nil {
+			return nil, errorString{fmt.Sprintf("minutes: %s is not a slurm time", FormatValue(v))}
+		}
+		return minutes, nil
+	}
+
+	duration, err := ParseSlurmTime(s)
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("minutes: %s is not a slurm time", s)}
+	}
+	totalSeconds := int64(duration.Seconds())
+	if totalSeconds%60 == 0 {
+		return totalSeconds / 60, nil
+	}
+	return float64(totalSeconds) / 60, nil
+}
+
+// Formats a number of minutes, or any slurm time limit, as
+// `HH:MM:SS`, or `D-HH:MM:SS` if it is at least a day. Fractions of a
+// second are rounded up.
+func FormatDuration(v any) (string, error) {
+	minutes, err := ParseMinutes(v)
+	if err != nil {
+		return "", errorString{fmt.Sprintf("duration: %s is not a number of minutes or a slurm time", FormatValue(v))}
+	}
+	m, _ := AsFloat(minutes)
+	if m < 0 {
+		return "", errorString{fmt.Sprintf("duration: %s is negative", FormatValue(v))}
+	}
+	return FormatSlurmDuration(time.Duration(math.Ceil(m*60))*time.Second, true), nil
+}
+
+var memoryRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([KMGT]?)B?$`)
+
+var memoryUnits = []struct {
+	suffix string
+	kb     int64
+}{{"T", 1 << 30}, {"G", 1 << 20}, {"M", 1 << 10}, {"K", 1}}
+
+// Parses a slurm memory size such as `4G` or `500M` into kilobytes.
+// As in slurm, a number without a unit is in megabytes.
+func ParseMemory(v any) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(FormatValue(v)))
+	match := memoryRegexp.FindStringSubmatch(s)
+	if match == nil {
+		return 0, errorString{fmt.Sprintf("%s is not a memory size - expected e.g. 4G or 500M", FormatValue(v))}
+	}
+
+	amount, _ := strconv.ParseFloat(match[1], 64)
+	unit := match[2]
+	if unit == "" {
+		unit = "M"
+	}
+	for _, u := range memoryUnits {
+		if u.suffix == unit {
+			return int64(math.Ceil(amount * float64(u.kb))), nil
+		}
+	}
+	return 0, errorString{fmt.Sprintf("%s has an unknown memory unit", FormatValue(v))}
+}
+
+// Formats a number of kilobytes using the largest unit that represents
+// it exactly
+func FormatMemory(kb int64) string {
+	for _, u := range memoryUnits {
+		if kb%u.kb == 0 {
+			return fmt.Sprintf("%d%s", kb/u.kb, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dK", kb)
+}
+
+// Multiplies a slurm memory size by `factor`, e.g. `mulMemory 1.5 "4G"`
+// is `6G`
+func MultiplyMemory(factor any, mem any) (string, error) {
+	f, err := AsFloat(factor)
+	if err != nil {
+		return "", errorString{fmt.Sprintf("mulMemory: factor %s", err)}
+	}
+	if f < 0 {
+		return "", errorString{fmt.Sprintf("mulMemory: factor %s is negative", FormatValue(factor))}
+	}
+	kb, err := ParseMemory(mem)
+	if err != nil {
+		return "", errorString{fmt.Sprintf("mulMemory: %s", err)}
+	}
+	return FormatMemory(int64(math.Ceil(float64(kb) * f))), nil
+}
+
+// Returns a slurm memory size in megabytes, rounded up
+func MemoryMB(mem any) (int64, error) {
+	kb, err := ParseMemory(mem)
+	if err != nil {
+		return 0, errorString{fmt.Sprintf("memoryMB: %s", err)}
+	}
+	return (kb + 1023) / 1024, nil
+}
+
+// Returns `fallback` if `v` is nil, an empty string, or an empty list
+// or object, and `v` otherwise. Zero and false are not considered
+// empty, since they are meaningful settings.
+func DefaultValue(fallback any, v any) any {
+	switch value := v.(type) {
+	case nil:
+		return fallback
+	case string:
+		if value == "" {
+			return fallback
+		}
+	case []any:
+		if len(value) == 0 {
+			return fallback
+		}
+	case map[string]any:
+		if len(value) == 0 {
+			return fallback
+		}
+	}
+	return v
+}
+
+// Encodes `v` as JSON
+func JSONValue(v any) (string, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", errorString{fmt.Sprintf("json: %s", err)}
+	}
+	return string(encoded), nil
+}
+
+// Returns the integers from `start` to `end` inclusive, as with
+// @range. With a single argument, returns 1 to `end`, or nothing if
+// `end` is less than 1.
+func Sequence(args ...any) ([]int64, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, errorString{fmt.Sprintf("seq: expected between 1 and 3 arguments, got %d", len(args))}
+	}
+	ints := make([]int64, len(args))
+	for i, arg := range args {
+		n, err := AsInt(arg)
+		if err != nil {
+			return nil, errorString{fmt.Sprintf("seq: %s", err)}
+		}
+		ints[i] = int64(n)
+	}
+
+	start, end := int64(1), ints[0]
+	if len(ints) == 1 && end < 1 {
+		return []int64{}, nil
+	}
+	if len(ints) > 1 {
+		start, end = ints[0], ints[1]
+	}
+	step := int64(1)
+	if end < start {
+		step = -1
+	}
+	if len(ints) > 2 {
+		step = ints[2]
+	}
+	if step == 0 || (end-start)*step < 0 {
+		return nil, errorString{fmt.Sprintf("seq: step %d does not lead from %d to %d", step, start, end)}
+	}
+
+	nValues := (end-start)/step + 1
+	if nValues > maxGeneratedValues {
+		return nil, errorString{fmt.Sprintf("seq: generates %d values, more than the maximum of %d", nValues, maxGeneratedValues)}
+	}
+	values := make([]int64, 0, nValues)
+	for i := int64(0); i < nValues; i++ {
+		values = append(values, start+i*step)
+	}
+	return values, nil
+}
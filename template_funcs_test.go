@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"text/template"
+)
+
+func TestAtoi(t *testing.T) {
+	tmpl := template.Must(template.New("t").Funcs(TemplateFuncs()).Parse(`{{add (atoi .v) 1}}`))
+	for _, v := range []any{"41", int64(41), 41.0} {
+		got, err := RenderTemplate(tmpl, map[string]any{"v": v})
+		if err != nil {
+			t.Errorf("atoi %#v returned error: %s", v, err)
+			continue
+		}
+		if got != "42" {
+			t.Errorf("atoi %#v rendered %q, want \"42\"", v, got)
+		}
+	}
+
+	for _, v := range []any{"abc", 41.5, true} {
+		if _, err := RenderTemplate(tmpl, map[string]any{"v": v}); err == nil {
+			t.Errorf("atoi %#v should have failed", v)
+		}
+	}
+}
@@ -1,48 +1,233 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"text/template"
 )
 
-func GetScriptTemplate(sim_dir string) (template.Template, error) {
-	script_bytes, err := os.ReadFile(filepath.Join(sim_dir, ".glurmo", "script_template"))
+// Returns the script template of `sim_dir`, along with its partials
+// and the overrides that apply to `levels` (see `GetTemplate`)
+func GetScriptTemplate(sim_dir string, levels []string) (template.Template, error) {
+	return GetTemplate(sim_dir, "script_template", "Script Template", levels)
+}
+
+// Returns the slurm template of `sim_dir`, along with its partials
+// and the overrides that apply to `levels` (see `GetTemplate`)
+func GetSlurmTemplate(sim_dir string, levels []string) (template.Template, error) {
+	return GetTemplate(sim_dir, "slurm_template", "Slurm Template", levels)
+}
+
+// Returns the optional condor template of `sim_dir`, along with its
+// partials and the overrides that apply to `levels` (see `GetTemplate`)
+func GetCondorTemplate(sim_dir string, levels []string) (template.Template, error) {
+	return GetTemplate(sim_dir, "condor_template", "Condor Template", levels)
+}
+
+// Parses template `templateName` of the .glurmo directory of `simDir`,
+// followed by every file in .glurmo/partials, so that both templates
+// can `{{template}}` the blocks the partials `{{define}}`. Finally the
+// files in .glurmo/overrides/<level> are parsed for each of `levels`
+// (e.g. `method_lasso`), replacing the blocks they redefine. If
+// `levels` is nil, every override directory is used, which is how the
+// templates of a set up leaf, holding only its own overrides, are read.
+func GetTemplate(simDir string, templateName string, name string, levels []string) (template.Template, error) {
+	contents, err := os.ReadFile(filepath.Join(simDir, ".glurmo", templateName))
+	if err != nil {
+		return template.Template{}, err
+	}
+
+	tmpl, err := template.New(name).Funcs(TemplateFuncs()).Parse(string(contents))
 	if err != nil {
 		return template.Template{}, err
 	}
 
-	script_string := string(script_bytes)
+	partialFiles, err := GetPartialFiles(simDir)
+	if err != nil {
+		return template.Template{}, err
+	}
+	for _, file := range partialFiles {
+		tmpl, err = ParseTemplateFile(tmpl, simDir, file)
+		if err != nil {
+			return template.Template{}, err
+		}
+	}
 
-	// TODO: check if script contains {{.results_path}} and throw
-	// warning if not
-	script_template := template.New("Script Template")
-	script_template, err = script_template.Parse(script_string)
+	overrideFiles, err := GetOverrideFiles(simDir, levels)
 	if err != nil {
 		return template.Template{}, err
 	}
+	overriddenBy := make(map[string]string)
+	for _, file := range overrideFiles {
+		blocks, err := DefinedBlocks(simDir, file)
+		if err != nil {
+			return template.Template{}, err
+		}
+		for _, block := range blocks {
+			if other, overridden := overriddenBy[block]; overridden {
+				return template.Template{}, errorString{fmt.Sprintf("block \"%s\" is overridden by both %s and %s", block, other, file)}
+			}
+			overriddenBy[block] = file
+		}
 
-	return *script_template, nil
+		tmpl, err = ParseTemplateFile(tmpl, simDir, file)
+		if err != nil {
+			return template.Template{}, err
+		}
+	}
+
+	return *tmpl, nil
 }
 
-func GetSlurmTemplate(sim_dir string) (template.Template, error) {
-	funcMap := template.FuncMap{
-		"atoi": strconv.Atoi,
+// Parses `file`, relative to the .glurmo directory of `simDir`, into
+// the template set of `tmpl`
+func ParseTemplateFile(tmpl *template.Template, simDir string, file string) (*template.Template, error) {
+	contents, err := os.ReadFile(filepath.Join(simDir, ".glurmo", file))
+	if err != nil {
+		return nil, err
 	}
+	_, err = tmpl.New(file).Parse(string(contents))
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
 
-	slurm_bytes, err := os.ReadFile(filepath.Join(sim_dir, ".glurmo", "slurm_template"))
+// Returns the names of the blocks `file`, relative to the .glurmo
+// directory of `simDir`, defines
+func DefinedBlocks(simDir string, file string) ([]string, error) {
+	contents, err := os.ReadFile(filepath.Join(simDir, ".glurmo", file))
 	if err != nil {
-		return template.Template{}, err
+		return nil, err
+	}
+	tmpl, err := template.New(file).Funcs(TemplateFuncs()).Parse(string(contents))
+	if err != nil {
+		return nil, err
 	}
 
-	slurm_string := string(slurm_bytes)
+	blocks := make([]string, 0)
+	for _, t := range tmpl.Templates() {
+		if t.Name() != file {
+			blocks = append(blocks, t.Name())
+		}
+	}
+	slices.Sort(blocks)
+	return blocks, nil
+}
 
-	slurm_template := template.New("Slurm Template").Funcs(funcMap)
-	slurm_template, err = slurm_template.Parse(slurm_string)
+// Returns the files of the .glurmo/partials directory of `simDir`,
+// relative to .glurmo and in the order they are parsed
+func GetPartialFiles(simDir string) ([]string, error) {
+	return ListTemplateFiles(filepath.Join(simDir, ".glurmo"), "partials")
+}
+
+// Returns the files of the .glurmo/overrides directory of `simDir`
+// that apply to a simulation with the given levels, relative to .glurmo
+// and in the order they are parsed. If `levels` is nil, returns the
+// files of every override directory.
+func GetOverrideFiles(simDir string, levels []string) ([]string, error) {
+	glurmoDir := filepath.Join(simDir, ".glurmo")
+	if levels == nil {
+		var err error
+		levels, err = GetOverrideLevels(simDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files := make([]string, 0)
+	for _, level := range levels {
+		levelFiles, err := ListTemplateFiles(glurmoDir, filepath.Join("overrides", level))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, levelFiles...)
+	}
+	return files, nil
+}
+
+// Returns the names of the subdirectories of .glurmo/overrides in
+// `simDir`, i.e. the levels that have overrides
+func GetOverrideLevels(simDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(simDir, ".glurmo", "overrides"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
 	if err != nil {
-		return template.Template{}, err
+		return nil, err
+	}
+
+	levels := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			levels = append(levels, entry.Name())
+		}
 	}
+	return levels, nil
+}
 
-	return *slurm_template, nil
+// Returns the paths, relative to `glurmoDir`, of the files in
+// subdirectory `dir` of `glurmoDir`, sorted and skipping hidden
+// files. A missing directory has no files.
+func ListTemplateFiles(glurmoDir string, dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(glurmoDir, dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && entry.Name()[0] != '.' {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// Stages copying the templates and partials of `srcDir`, along with
+// the overrides for `levels`, into the .glurmo directory of `destDir`,
+// replacing any partials and overrides it had before
+func CopyTemplates(t *Transaction, srcDir string, destDir string, levels []string) error {
+	for _, dir := range []string{"partials", "overrides"} {
+		err := t.Remove(filepath.Join(destDir, ".glurmo", dir))
+		if err != nil {
+			return err
+		}
+	}
+
+	partialFiles, err := GetPartialFiles(srcDir)
+	if err != nil {
+		return err
+	}
+	overrideFiles, err := GetOverrideFiles(srcDir, levels)
+	if err != nil {
+		return err
+	}
+
+	files := append([]string{"script_template", "slurm_template"}, partialFiles...)
+	hasCondorTemplate, err := FileExists(filepath.Join(srcDir, ".glurmo", "condor_template"))
+	if err != nil {
+		return err
+	}
+	if hasCondorTemplate {
+		files = append(files, "condor_template")
+	}
+	for _, file := range append(files, overrideFiles...) {
+		contents, err := os.ReadFile(filepath.Join(srcDir, ".glurmo", file))
+		if err != nil {
+			return errorString{fmt.Sprintf("could not copy %s to %s: %s", file, destDir, err)}
+		}
+		err = t.WriteFile(filepath.Join(destDir, ".glurmo", file), contents)
+		if err != nil {
+			return errorString{fmt.Sprintf("could not copy %s to %s: %s", file, destDir, err)}
+		}
+	}
+	return nil
 }
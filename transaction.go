@@ -0,0 +1,347 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// A set of changes to a simulation directory that are staged in a
+// directory under its .glurmo subdirectory and only applied by
+// `Commit`. Until then, the simulation directory itself is untouched.
+// If applying the changes fails, those already applied are undone, so
+// the simulation directory is left as it was.
+type Transaction struct {
+	// Drop writes to files that already exist and removals of anything
+	// that exists, so nothing is overwritten
+	NoClobber bool
+
+	simDir     string
+	stagingDir string
+	writes     []string
+	dirs       []string
+	removals   []string
+	skipped    []string
+	undo       []func() error
+}
+
+// Starts a transaction on `simDir`
+func NewTransaction(simDir string) (*Transaction, error) {
+	stagingDir, err := os.MkdirTemp(filepath.Join(simDir, ".glurmo"), ".staging-")
+	if err != nil {
+		return nil, errorString{fmt.Sprintf("could not create staging directory: %s", err)}
+	}
+	return &Transaction{simDir: filepath.Clean(simDir), stagingDir: stagingDir}, nil
+}
+
+// Returns the path of `path`, which must be inside the simulation
+// directory, relative to the simulation directory
+func (t *Transaction) rel(path string) (string, error) {
+	rel, err := filepath.Rel(t.simDir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errorString{fmt.Sprintf("%s is not inside %s", path, t.simDir)}
+	}
+	return rel, nil
+}
+
+// Returns where the new contents of `rel` are staged
+func (t *Transaction) stagedPath(rel string) string {
+	return filepath.Join(t.stagingDir, "new", rel)
+}
+
+// Returns where the old contents of `rel` are kept until the
+// transaction is done
+func (t *Transaction) backupPath(rel string) string {
+	return filepath.Join(t.stagingDir, "old", rel)
+}
+
+// Stages writing `contents` to `path`
+func (t *Transaction) WriteFile(path string, contents []byte) error {
+	rel, err := t.rel(path)
+	if err != nil {
+		return err
+	}
+	if t.NoClobber && t.exists(path) {
+		t.skip(path)
+		return nil
+	}
+	staged := t.stagedPath(rel)
+	err = os.MkdirAll(filepath.Dir(staged), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(staged, contents, 0700)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(t.writes, path) {
+		t.writes = append(t.writes, path)
+	}
+	return nil
+}
+
+// Stages creating directory `path` and any missing parents
+func (t *Transaction) MkdirAll(path string) error {
+	_, err := t.rel(path)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(t.dirs, path) {
+		t.dirs = append(t.dirs, path)
+	}
+	return nil
+}
+
+// Stages removing `path`, a file or directory, if it exists. Removals
+// are applied before any writes or new directories.
+func (t *Transaction) Remove(path string) error {
+	_, err := t.rel(path)
+	if err != nil {
+		return err
+	}
+	if t.NoClobber && t.exists(path) {
+		t.skip(path)
+		return nil
+	}
+	if !slices.Contains(t.removals, path) {
+		t.removals = append(t.removals, path)
+	}
+	return nil
+}
+
+// Checks whether `path` currently exists on disk
+func (t *Transaction) exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func (t *Transaction) skip(path string) {
+	if !slices.Contains(t.skipped, path) {
+		t.skipped = append(t.skipped, path)
+	}
+}
+
+// Returns the existing paths that writes or removals were dropped for
+// because of `NoClobber`
+func (t *Transaction) Skipped() []string {
+	return t.skipped
+}
+
+// Returns the existing files that the staged writes would overwrite,
+// and the existing files and directories that would be removed. Paths
+// below a removed directory are only reported as the directory.
+func (t *Transaction) Clobbered() ([]string, []string) {
+	overwritten := make([]string, 0)
+	for _, path := range t.writes {
+		if !t.IsRemoved(path) && t.exists(path) {
+			overwritten = append(overwritten, path)
+		}
+	}
+
+	removed := make([]string, 0)
+	for _, path := range t.removals {
+		if !t.exists(path) {
+			continue
+		}
+		underRemoval := false
+		for _, other := range t.removals {
+			if strings.HasPrefix(path, other+string(filepath.Separator)) {
+				underRemoval = true
+			}
+		}
+		if !underRemoval {
+			removed = append(removed, path)
+		}
+	}
+	return overwritten, removed
+}
+
+// Checks whether `path` is staged to be removed, along with everything
+// below it
+func (t *Transaction) IsRemoved(path string) bool {
+	for _, removal := range t.removals {
+		if path == removal || strings.HasPrefix(path, removal+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the current contents of `path` as the transaction would
+// leave them: staged contents if it is written, nothing if it is
+// removed, and otherwise what is on disk
+func (t *Transaction) ReadFile(path string) ([]byte, error) {
+	if slices.Contains(t.writes, path) {
+		rel, err := t.rel(path)
+		if err != nil {
+			return nil, err
+		}
+		return os.ReadFile(t.stagedPath(rel))
+	}
+	if t.IsRemoved(path) {
+		return nil, fs.ErrNotExist
+	}
+	return os.ReadFile(path)
+}
+
+// Applies the staged changes. If any of them fails, the ones already
+// applied are undone.
+func (t *Transaction) Commit() error {
+	err := t.Apply()
+	if err != nil {
+		return err
+	}
+	t.Discard()
+	return nil
+}
+
+// Applies the staged changes like `Commit`, but keeps the previous
+// contents of the simulation directory until `Discard` is called, so
+// that `Undo` can still restore them. This lets several transactions
+// be committed all together or not at all.
+func (t *Transaction) Apply() error {
+	err := t.apply()
+	if err != nil {
+		rollbackErr := t.rollback()
+		if rollbackErr != nil {
+			return errorString{fmt.Sprintf("%s, and could not restore %s: %s (its previous contents are in %s)",
+				err, t.simDir, rollbackErr, t.backupPath(""))}
+		}
+		t.Discard()
+		return errorString{fmt.Sprintf("%s - %s was left unchanged", err, t.simDir)}
+	}
+	return nil
+}
+
+// Undoes the changes of a transaction that was applied with `Apply`,
+// restoring the simulation directory as it was
+func (t *Transaction) Undo() error {
+	err := t.rollback()
+	if err != nil {
+		return errorString{fmt.Sprintf("could not restore %s: %s (its previous contents are in %s)",
+			t.simDir, err, t.backupPath(""))}
+	}
+	t.Discard()
+	return nil
+}
+
+// Discards the staged changes, leaving the simulation directory as it
+// is
+func (t *Transaction) Discard() {
+	os.RemoveAll(t.stagingDir)
+}
+
+func (t *Transaction) apply() error {
+	for _, path := range t.removals {
+		// Removing a directory removes everything below it, and its
+		// backup would clash with theirs
+		if t.IsRemoved(filepath.Dir(path)) {
+			continue
+		}
+		err := t.moveToBackup(path)
+		if err != nil {
+			return errorString{fmt.Sprintf("could not remove %s: %s", path, err)}
+		}
+	}
+
+	for _, dir := range t.dirs {
+		err := t.mkdirAll(dir)
+		if err != nil {
+			return errorString{fmt.Sprintf("could not create %s: %s", dir, err)}
+		}
+	}
+
+	for _, path := range t.writes {
+		path := path
+		err := t.mkdirAll(filepath.Dir(path))
+		if err != nil {
+			return errorString{fmt.Sprintf("could not create %s: %s", filepath.Dir(path), err)}
+		}
+		err = t.moveToBackup(path)
+		if err != nil {
+			return errorString{fmt.Sprintf("could not replace %s: %s", path, err)}
+		}
+
+		rel, _ := t.rel(path)
+		err = os.Rename(t.stagedPath(rel), path)
+		if err != nil {
+			return errorString{fmt.Sprintf("could not write %s: %s", path, err)}
+		}
+		t.undo = append(t.undo, func() error {
+			return os.Remove(path)
+		})
+	}
+
+	return nil
+}
+
+// Moves `path`, if it exists, to the backup directory
+func (t *Transaction) moveToBackup(path string) error {
+	_, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rel, _ := t.rel(path)
+	backup := t.backupPath(rel)
+	err = os.MkdirAll(filepath.Dir(backup), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path, backup)
+	if err != nil {
+		return err
+	}
+	t.undo = append(t.undo, func() error {
+		return os.Rename(backup, path)
+	})
+	return nil
+}
+
+// Creates `dir` and any missing parents, remembering which ones were
+// created so they can be removed again
+func (t *Transaction) mkdirAll(dir string) error {
+	missing := make([]string, 0)
+	for d := dir; d != t.simDir && d != filepath.Dir(d); d = filepath.Dir(d) {
+		_, err := os.Stat(d)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		missing = append(missing, d)
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		d := missing[i]
+		err := os.Mkdir(d, 0700)
+		if err != nil {
+			return err
+		}
+		t.undo = append(t.undo, func() error {
+			return os.Remove(d)
+		})
+	}
+	return nil
+}
+
+// Undoes the changes applied so far, most recent first
+func (t *Transaction) rollback() error {
+	var rollbackErr error
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		err := t.undo[i]()
+		if err != nil && rollbackErr == nil {
+			rollbackErr = err
+		}
+	}
+	t.undo = nil
+	return rollbackErr
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransactionRemovesNestedPaths(t *testing.T) {
+	simDir := t.TempDir()
+	leafDir := filepath.Join(simDir, "n_1000", "p_1")
+	for _, dir := range []string{filepath.Join(simDir, ".glurmo"), leafDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tr, err := NewTransaction(simDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A leaf, then the directory holding it, as `RemoveLeaf` does
+	for _, path := range []string{leafDir, filepath.Dir(leafDir)} {
+		if err := tr.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatalf("Commit failed: %s", err)
+	}
+	if _, err := os.Stat(filepath.Dir(leafDir)); !os.IsNotExist(err) {
+		t.Errorf("%s should have been removed", filepath.Dir(leafDir))
+	}
+}
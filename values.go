@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Converts a decoded settings value to the types glurmo works with:
+// integers become `int64`, other numbers `float64`, and objects and
+// arrays `map[string]any` and `[]any`, recursively
+func NormalizeValue(v any) any {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case int:
+		return int64(value)
+	case int32:
+		return int64(value)
+	case uint64:
+		return int64(value)
+	case float32:
+		return float64(value)
+	case map[string]any:
+		return NormalizeMap(value)
+	case []map[string]any:
+		normalized := make([]any, 0, len(value))
+		for _, element := range value {
+			normalized = append(normalized, NormalizeMap(element))
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, 0, len(value))
+		for _, element := range value {
+			normalized = append(normalized, NormalizeValue(element))
+		}
+		return normalized
+	}
+	return v
+}
+
+// Applies `NormalizeValue` to every value of `m`. A nil map becomes
+// an empty map.
+func NormalizeMap(m map[string]any) map[string]any {
+	normalized := make(map[string]any, len(m))
+	for k, v := range m {
+		normalized[k] = NormalizeValue(v)
+	}
+	return normalized
+}
+
+// Returns a deep copy of a settings value
+func DeepCopyValue(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		return DeepCopyMap(value)
+	case []any:
+		copied := make([]any, 0, len(value))
+		for _, element := range value {
+			copied = append(copied, DeepCopyValue(element))
+		}
+		return copied
+	}
+	return v
+}
+
+// Returns a deep copy of a map of settings values
+func DeepCopyMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]any, len(m))
+	for k, v := range m {
+		copied[k] = DeepCopyValue(v)
+	}
+	return copied
+}
+
+// Formats a settings value as it appears in directory names and
+// simulation ids
+func FormatValue(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+
+	valueJSON, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(valueJSON)
+}
+
+// Returns setting `key` of `m` formatted as a string, or the empty
+// string if it is not set
+func GetString(m map[string]any, key string) string {
+	return FormatValue(m[key])
+}
+
+// Converts a settings value to an integer. Accepts integers, floats
+// without a fractional part, and strings containing integers.
+func AsInt(v any) (int, error) {
+	switch value := v.(type) {
+	case int:
+		return value, nil
+	case int64:
+		return int(value), nil
+	case float64:
+		if value == float64(int(value)) {
+			return int(value), nil
+		}
+	case string:
+		return strconv.Atoi(value)
+	}
+	return 0, errorString{fmt.Sprintf("%s is not an integer", FormatValue(v))}
+}
+
+// Checks whether `v` is a string, number or boolean, i.e. can be
+// used as the value of a list variable
+func IsScalar(v any) bool {
+	switch v.(type) {
+	case string, int64, float64, bool:
+		return true
+	}
+	return false
+}
+
+// Converts an element of a list written as a string, such as
+// `@[1, 2.5, a]` or `@range(1, 10)`, to the value it would have in an
+// array: `int64` or `float64` for numbers, and the string itself
+// otherwise. Numbers that would not be formatted back the same way,
+// e.g. `007`, stay strings, so that they keep naming the same
+// directories.
+func ParseListElement(s string) any {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil && FormatValue(i) == s {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && FormatValue(f) == s {
+		return f
+	}
+	return s
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListValuesTyped(t *testing.T) {
+	cases := []struct {
+		list any
+		want []any
+	}{
+		{[]any{int64(1), int64(2)}, []any{int64(1), int64(2)}},
+		{"@[1, 2]", []any{int64(1), int64(2)}},
+		{"@[a, 1.5, -3, true]", []any{"a", 1.5, int64(-3), "true"}},
+		{"@range(1, 3)", []any{int64(1), int64(2), int64(3)}},
+		{"@seq(8, 10, 1, 2)", []any{"08", "09", "10"}},
+		{"@linspace(0, 1, 3)", []any{int64(0), 0.5, int64(1)}},
+		// Kept as written, so that directory names do not change
+		{"@[1.50, 007, +4]", []any{"1.50", "007", "+4"}},
+	}
+	for _, c := range cases {
+		got, err := ListValues(c.list)
+		if err != nil {
+			t.Errorf("ListValues(%v) returned error: %s", c.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ListValues(%v) = %#v, want %#v", c.list, got, c.want)
+		}
+	}
+}